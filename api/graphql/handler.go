@@ -2,12 +2,11 @@ package graphql
 
 import (
 	"context"
-	"errors"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/graphql-go/graphql"
-	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/client/export"
 	"github.com/wpajqz/linker/codec"
 )
 
@@ -27,22 +26,14 @@ var queryType = graphql.NewObject(graphql.ObjectConfig{
 				method := p.Args["method"]
 				param := p.Args["param"]
 
-				session, err := brpc.Session()
-				if err != nil {
-					return nil, err
-				}
-
-				var (
-					b           []byte
-					errCallback error
-				)
-
 				ctx := p.Context.Value("ctx").(*gin.Context)
+				// 每个http header原样转发, 多个值不再拼接
+				header := make(linker.Header, len(ctx.Request.Header))
 				for k, v := range ctx.Request.Header {
-					session.SetRequestProperty(k, strings.Join(v, ","))
+					header[k] = append([]string(nil), v...)
 				}
 
-				coder, err := codec.NewCoder(session.GetContentType())
+				coder, err := codec.NewCoder(brpc.ContentType())
 				if err != nil {
 					return nil, err
 				}
@@ -55,31 +46,23 @@ var queryType = graphql.NewObject(graphql.ObjectConfig{
 					}
 				}
 
-				to, _ := context.WithTimeout(context.Background(), ctx.GetDuration("timeout"))
-				err = session.SyncSendWithTimeout(to, method.(string), body, client.RequestStatusCallback{
-					Success: func(header, body []byte) {
-						for _, v := range strings.Split(string(header), ";") {
-							if len(v) > 0 {
-								ss := strings.Split(v, "=")
-								if len(ss) > 1 {
-									ctx.Writer.Header().Set(ss[0], ss[1])
-								}
-							}
-						}
+				to, cancel := context.WithTimeout(context.Background(), ctx.GetDuration("timeout"))
+				defer cancel()
 
-						b = body
-					},
-					Error: func(code int, message string) {
-						errCallback = errors.New(message)
-					},
-				})
+				var (
+					b     []byte
+					reply linker.Header
+				)
 
+				err = brpc.Call(to, method.(string), body, &b, export.WithHeader(header), export.ReceiveHeader(&reply))
 				if err != nil {
 					return nil, err
 				}
 
-				if errCallback != nil {
-					return nil, errCallback
+				for k, v := range reply {
+					for _, vv := range v {
+						ctx.Writer.Header().Add(k, vv)
+					}
 				}
 
 				return string(b), nil
@@ -2,14 +2,13 @@ package http
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/wpajqz/linker"
 	"github.com/wpajqz/linker/api"
 	"github.com/wpajqz/linker/client"
 	"github.com/wpajqz/linker/client/export"
@@ -41,59 +40,39 @@ func (ha *httpAPI) Dial(network, address string) error {
 	app := gin.Default()
 
 	app.POST("/rpc", func(ctx *gin.Context) {
-		session, err := brpc.Session()
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
-			return
-		}
-
 		var req request
 		if err := ctx.Bind(&req); err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
 			return
 		}
 
-		var (
-			b           []byte
-			errCallback error
-		)
-
+		// 每个http header原样转发, 多个值不再拼接
+		header := make(linker.Header, len(ctx.Request.Header))
 		for k, v := range ctx.Request.Header {
-			session.SetRequestProperty(k, strings.Join(v, ","))
+			header[k] = append([]string(nil), v...)
 		}
 
-		to, _ := context.WithTimeout(context.Background(), ha.options.timeout)
-		err = session.SyncSendWithTimeout(to, req.Method, req.Param, client.RequestStatusCallback{
-			Success: func(header, body []byte) {
-				for _, v := range strings.Split(string(header), ";") {
-					if len(v) > 0 {
-						ss := strings.Split(v, "=")
-						if len(ss) > 1 {
-							ctx.Writer.Header().Set(ss[0], ss[1])
-						}
-					}
-				}
+		to, cancel := context.WithTimeout(context.Background(), ha.options.timeout)
+		defer cancel()
 
-				b = body
-			},
-			Error: func(code int, message string) {
-				errCallback = errors.New(message)
-			},
-		})
+		var (
+			b     []byte
+			reply linker.Header
+		)
 
+		err := brpc.Call(to, req.Method, req.Param, &b, export.WithHeader(header), export.ReceiveHeader(&reply))
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{"msg": err.Error()})
 			return
 		}
 
-		if errCallback != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"msg": errCallback.Error()})
-			return
+		for k, v := range reply {
+			for _, vv := range v {
+				ctx.Writer.Header().Add(k, vv)
+			}
 		}
 
-		ctx.Data(http.StatusOK, session.GetContentType(), b)
-
-		return
+		ctx.Data(http.StatusOK, brpc.ContentType(), b)
 	})
 
 	app.GET("/rpc/websocket", func(ctx *gin.Context) {
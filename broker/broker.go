@@ -1,5 +1,7 @@
 package broker
 
+// Broker 在服务实例之间传递消息. 同一个订阅收到的消息按照发布的顺序依次交给process,
+// Publish不等待订阅者处理, 发布时没有订阅者的主题不缓存消息
 type Broker interface {
 	Publish(topic string, message interface{}) error
 	Subscribe(nodeID, topic string, process func([]byte)) error
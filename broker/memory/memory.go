@@ -7,66 +7,70 @@ import (
 	"github.com/wpajqz/linker/broker/memory/pubsub"
 )
 
-type (
-	memoryBroker struct {
-		ps sync.Map
-		pf sync.Map
-	}
-
-	topicMap map[string]func([]byte)
-)
+type memoryBroker struct {
+	mutex sync.RWMutex
+	nodes map[string]*pubsub.PubSub
+}
 
 func (mb *memoryBroker) Publish(topic string, message interface{}) error {
-	mb.ps.Range(func(key, value interface{}) bool {
-		ps := value.(*pubsub.PubSub)
-		err := ps.Publish(topic, message)
-		if err != nil {
-			return false
-		}
+	// 发布时不持有锁, 订阅者处理得慢时不影响其它节点订阅和取消订阅
+	mb.mutex.RLock()
+	nodes := make([]*pubsub.PubSub, 0, len(mb.nodes))
+	for _, ps := range mb.nodes {
+		nodes = append(nodes, ps)
+	}
+	mb.mutex.RUnlock()
 
-		return true
-	})
+	for _, ps := range nodes {
+		if err := ps.Publish(topic, message); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 func (mb *memoryBroker) Subscribe(nodeID, topic string, process func([]byte)) error {
-	actual, _ := mb.ps.LoadOrStore(nodeID, pubsub.New())
-	if av, ok := actual.(*pubsub.PubSub); ok {
-		actual, _ := mb.pf.LoadOrStore(nodeID, make(topicMap))
-		tm := actual.(topicMap)
-		if tm[topic] == nil {
-			tm[topic] = process
-		}
-
-		err := av.Subscribe(topic, func(i interface{}) {
-			if msg, ok := i.([]byte); ok {
-				tm[topic](msg)
-			}
-		})
-
-		return err
+	mb.mutex.Lock()
+	ps, ok := mb.nodes[nodeID]
+	if !ok {
+		ps = pubsub.New()
+		mb.nodes[nodeID] = ps
 	}
+	mb.mutex.Unlock()
 
-	return nil
+	return ps.Subscribe(topic, func(i interface{}) {
+		if msg, ok := i.([]byte); ok {
+			process(msg)
+		}
+	})
 }
 
 func (mb *memoryBroker) UnSubscribe(nodeID, topic string) error {
-	if v, ok := mb.ps.Load(nodeID); ok {
-		if v != nil {
-			v.(*pubsub.PubSub).UnSubscribe(topic)
-		}
+	mb.mutex.RLock()
+	ps, ok := mb.nodes[nodeID]
+	mb.mutex.RUnlock()
+
+	if ok {
+		ps.UnSubscribe(topic)
 	}
 
 	return nil
 }
 
 func (mb *memoryBroker) UnSubscribeAll(nodeID string) error {
-	mb.ps.Delete(nodeID)
+	mb.mutex.Lock()
+	ps, ok := mb.nodes[nodeID]
+	delete(mb.nodes, nodeID)
+	mb.mutex.Unlock()
+
+	if ok {
+		ps.Close()
+	}
 
 	return nil
 }
 
 func NewBroker() broker.Broker {
-	return &memoryBroker{}
+	return &memoryBroker{nodes: make(map[string]*pubsub.PubSub)}
 }
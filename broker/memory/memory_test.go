@@ -0,0 +1,105 @@
+package memory_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker/broker/memory"
+)
+
+func TestBrokerOrder(t *testing.T) {
+	const total = 2000
+
+	b := memory.NewBroker()
+
+	received := make(chan int, total)
+	release := make(chan struct{})
+	if err := b.Subscribe("node", "topic", func(data []byte) {
+		<-release
+
+		v, _ := strconv.Atoi(string(data))
+		received <- v
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 订阅者还没有开始处理, 发布也不会阻塞
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+
+		for i := 0; i < total; i++ {
+			if err := b.Publish("topic", []byte(strconv.Itoa(i))); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked by a slow subscriber")
+	}
+
+	close(release)
+
+	for i := 0; i < total; i++ {
+		select {
+		case v := <-received:
+			if v != i {
+				t.Fatalf("message %d delivered out of order: %d", i, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %d was not delivered", i)
+		}
+	}
+}
+
+func TestBrokerNoSubscriber(t *testing.T) {
+	b := memory.NewBroker()
+
+	received := make(chan string, 1)
+	if err := b.Subscribe("node", "other", func(data []byte) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有订阅的主题不缓存消息, 之后订阅也不会收到
+	for i := 0; i < 2000; i++ {
+		if err := b.Publish("topic", []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.Subscribe("node", "topic", func(data []byte) { received <- string(data) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Publish("topic", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case v := <-received:
+		if v != "new" {
+			t.Errorf("unexpected message: %s", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
+
+	if err := b.UnSubscribe("node", "topic"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Publish("topic", []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case v := <-received:
+		t.Errorf("message delivered after unsubscribe: %s", v)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
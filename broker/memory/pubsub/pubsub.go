@@ -4,38 +4,110 @@ import "sync"
 
 type (
 	PubSub struct {
-		sync.Map
+		mutex  sync.RWMutex
+		topics map[string]*subscriber
 	}
 
-	topicChain chan interface{}
+	// subscriber 一个主题的订阅, 消息按照发布的顺序交给process处理.
+	// 队列没有长度限制, 订阅者处理得慢时不会阻塞发布
+	subscriber struct {
+		mutex   sync.Mutex
+		queue   []interface{}
+		wake    chan struct{}
+		done    chan struct{}
+		process func(interface{})
+	}
 )
 
 func New() *PubSub {
-	return &PubSub{}
+	return &PubSub{topics: make(map[string]*subscriber)}
 }
 
+// Subscribe 订阅主题, 已经订阅过的主题保持原来的处理函数
 func (ps *PubSub) Subscribe(topic string, process func(interface{})) error {
-	actual, _ := ps.LoadOrStore(topic, make(topicChain, 1000))
-	if ch, ok := actual.(topicChain); ok {
-		go func(chain topicChain) {
-			for msg := range ch {
-				go process(msg)
-			}
-		}(ch)
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if _, ok := ps.topics[topic]; ok {
+		return nil
 	}
 
+	s := &subscriber{wake: make(chan struct{}, 1), done: make(chan struct{}), process: process}
+	ps.topics[topic] = s
+
+	go s.run()
+
 	return nil
 }
 
+// Publish 发布消息, 不等待订阅者处理. 没有订阅的主题直接丢弃,
+// 不为它缓存消息, 否则从不订阅的主题会一直占用内存
 func (ps *PubSub) Publish(topic string, message interface{}) error {
-	actual, _ := ps.LoadOrStore(topic, make(topicChain, 1000))
-	if ch, ok := actual.(topicChain); ok {
-		ch <- message
+	ps.mutex.RLock()
+	s, ok := ps.topics[topic]
+	ps.mutex.RUnlock()
+
+	if ok {
+		s.push(message)
 	}
 
 	return nil
 }
 
 func (ps *PubSub) UnSubscribe(topic string) {
-	ps.Delete(topic)
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if s, ok := ps.topics[topic]; ok {
+		close(s.done)
+		delete(ps.topics, topic)
+	}
+}
+
+// Close 取消所有订阅
+func (ps *PubSub) Close() {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	for topic, s := range ps.topics {
+		close(s.done)
+		delete(ps.topics, topic)
+	}
+}
+
+func (s *subscriber) push(message interface{}) {
+	s.mutex.Lock()
+	s.queue = append(s.queue, message)
+	s.mutex.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run 依次处理队列中的消息, 取消订阅以后剩下的消息不再处理
+func (s *subscriber) run() {
+	for {
+		s.mutex.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mutex.Unlock()
+
+		for _, msg := range queue {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+
+			s.process(msg)
+		}
+
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
 }
@@ -6,17 +6,16 @@ import (
 
 	"github.com/go-redis/redis"
 	"github.com/wpajqz/linker/broker"
+	"github.com/wpajqz/linker/broker/memory/pubsub"
 )
 
-type (
-	redisBroker struct {
-		client *redis.Client
-		pb     sync.Map
-		pf     map[string]topicMap
-	}
-
-	topicMap map[string]func([]byte)
-)
+type redisBroker struct {
+	client *redis.Client
+	mutex  sync.RWMutex
+	pb     map[string]*redis.PubSub
+	// 收到的消息交给节点本地的订阅按照主题排队处理, 不阻塞redis连接的读取
+	pf map[string]*pubsub.PubSub
+}
 
 func (rb *redisBroker) Publish(topic string, message interface{}) error {
 	_, err := rb.client.Publish(topic, message).Result()
@@ -28,53 +27,59 @@ func (rb *redisBroker) Publish(topic string, message interface{}) error {
 }
 
 func (rb *redisBroker) Subscribe(nodeID, topic string, process func([]byte)) error {
-	var ps *redis.PubSub
-	if v, ok := rb.pb.Load(nodeID); ok {
-		ps = v.(*redis.PubSub)
-		err := ps.Subscribe(topic)
-		if err != nil {
-			return err
-		}
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
 
-		if rb.pf[nodeID] == nil {
-			rb.pf[nodeID] = make(topicMap)
-		}
+	local, ok := rb.pf[nodeID]
+	if !ok {
+		local = pubsub.New()
+		rb.pf[nodeID] = local
+	}
 
-		rb.pf[nodeID][topic] = process
-	} else {
-		ps = rb.client.Subscribe(topic)
-		rb.pb.Store(nodeID, ps)
+	if err := local.Subscribe(topic, func(i interface{}) {
+		process(i.([]byte))
+	}); err != nil {
+		return err
+	}
 
-		if rb.pf[nodeID] == nil {
-			rb.pf[nodeID] = make(topicMap)
-		}
+	if ps, ok := rb.pb[nodeID]; ok {
+		return ps.Subscribe(topic)
+	}
 
-		rb.pf[nodeID][topic] = process
+	ps := rb.client.Subscribe(topic)
+	rb.pb[nodeID] = ps
 
-		go func(ps *redis.PubSub) {
-			ch := ps.Channel()
-			for msg := range ch {
-				if v, ok := rb.pf[nodeID][msg.Channel]; ok {
-					go v([]byte(msg.Payload))
-				}
-			}
-		}(ps)
-	}
+	// 同一个主题的消息按照接收的顺序处理
+	go func(ps *redis.PubSub, local *pubsub.PubSub) {
+		for msg := range ps.Channel() {
+			_ = local.Publish(msg.Channel, []byte(msg.Payload))
+		}
+	}(ps, local)
 
 	return nil
 }
 
 func (rb *redisBroker) UnSubscribe(nodeID, topic string) error {
-	if v, ok := rb.pb.Load(nodeID); ok {
-		return v.(*redis.PubSub).Unsubscribe(topic)
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	if ps, ok := rb.pb[nodeID]; ok {
+		rb.pf[nodeID].UnSubscribe(topic)
+		return ps.Unsubscribe(topic)
 	}
 
 	return errors.New("node's subscriber is not found")
 }
 
 func (rb *redisBroker) UnSubscribeAll(nodeID string) error {
-	if v, ok := rb.pb.Load(nodeID); ok {
-		return v.(*redis.PubSub).Close()
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	if ps, ok := rb.pb[nodeID]; ok {
+		rb.pf[nodeID].Close()
+		delete(rb.pb, nodeID)
+		delete(rb.pf, nodeID)
+		return ps.Close()
 	}
 
 	return errors.New("node's subscriber is not found")
@@ -95,5 +100,5 @@ func NewBroker(opts ...Option) broker.Broker {
 		DB:       options.DB,
 	})
 
-	return &redisBroker{client: rc, pb: sync.Map{}, pf: make(map[string]topicMap)}
+	return &redisBroker{client: rc, pb: make(map[string]*redis.PubSub), pf: make(map[string]*pubsub.PubSub)}
 }
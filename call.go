@@ -0,0 +1,133 @@
+package linker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/wpajqz/linker/codec"
+)
+
+// Call没有设置截止时间时等待回复的时间
+const defaultCallTimeout = 10 * time.Second
+
+var (
+	// ErrNodeNotFound 节点没有连接到当前服务
+	ErrNodeNotFound = errors.New("linker: node not found")
+	// ErrConnClosed 等待回复时连接已经断开
+	ErrConnClosed = errors.New("linker: connection closed")
+)
+
+// call 发送请求并等待客户端的回复. 服务端主动调用时使用负数的sequence,
+// 和客户端请求使用的正数以及推送消息使用的0区分开, 客户端使用同样的operator和sequence回复
+func (c *Connection) call(ctx context.Context, operator uint32, body []byte) ([]byte, error) {
+	sequence := -atomic.AddInt64(&c.sequence, 1)
+
+	ch := make(chan Packet, 1)
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil, ErrConnClosed
+	}
+	c.calls[sequence] = ch
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.calls, sequence)
+		c.mutex.Unlock()
+	}()
+
+	if _, err := c.writePacket(operator, sequence, body); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply, ok := <-ch:
+		if !ok {
+			return nil, ErrConnClosed
+		}
+
+		h, err := DecodeHeader(reply.Header, reply.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		if code := h.Get("code"); code != "" {
+			v, _ := strconv.Atoi(code)
+			return nil, &Error{Code: v, Message: h.Get("message")}
+		}
+
+		return reply.Body, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolve 把客户端的回复交给等待的调用, 已经超时的回复直接丢弃
+func (c *Connection) resolve(reply Packet) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if ch, ok := c.calls[reply.Sequence]; ok {
+		ch <- reply
+		delete(c.calls, reply.Sequence)
+	}
+}
+
+// close 连接断开, 通知所有等待回复的调用
+func (c *Connection) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	for sequence, ch := range c.calls {
+		close(ch)
+		delete(c.calls, sequence)
+	}
+}
+
+// Call 调用节点上客户端通过HandleCall注册的处理器, 返回客户端回复的body.
+// ctx没有截止时间时最多等待defaultCallTimeout, 客户端回复错误时返回*Error.
+// 开启集群模式时节点可以在其它实例上
+func (s *Server) Call(ctx context.Context, nodeID, operator string, param interface{}) ([]byte, error) {
+	c := s.connections.Get(nodeID)
+	if c == nil && s.cluster == nil {
+		return nil, ErrNodeNotFound
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultCallTimeout)
+		defer cancel()
+	}
+
+	coder, err := codec.NewCoder(s.options.contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := coder.Encoder(param)
+	if err != nil {
+		return nil, err
+	}
+
+	// 节点不在本地时转发给集群中持有它的实例
+	if c == nil {
+		return s.cluster.call(ctx, nodeID, operator, body)
+	}
+
+	return c.call(ctx, s.operator(operator), body)
+}
+
+// operator 使用路由的operator表计算operator, 和客户端的配置保持一致
+func (s *Server) operator(pattern string) uint32 {
+	var table OperatorTable
+	if s.router != nil {
+		table = s.router.table.operators
+	}
+
+	return table.Operator(pattern)
+}
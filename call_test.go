@@ -0,0 +1,64 @@
+package linker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker/client/export"
+)
+
+func TestServerCall(t *testing.T) {
+	nodes := make(chan string, 1)
+
+	router := linker.NewRouter()
+	router.Route("/login", linker.HandlerFunc(func(ctx linker.Context) {
+		nodes <- ctx.NodeID()
+		ctx.Success(nil)
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	c := dial(t, address)
+	c.HandleCall("/status", export.CallHandlerFunc(func(header linker.Header, body []byte) (interface{}, error) {
+		return "ok:" + string(body), nil
+	}))
+	c.HandleCall("/reboot", export.CallHandlerFunc(func(header linker.Header, body []byte) (interface{}, error) {
+		return nil, &linker.Error{Code: linker.StatusForbidden, Message: "denied"}
+	}))
+
+	if err := c.SyncSend("/login", nil, client.RequestStatusCallback{}); err != nil {
+		t.Fatal(err)
+	}
+
+	id := <-nodes
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	body, err := s.Call(ctx, id, "/status", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != `"ok:1"` {
+		t.Errorf("unexpected reply: %s", body)
+	}
+
+	_, err = s.Call(ctx, id, "/reboot", nil)
+	if e, ok := err.(*linker.Error); !ok || e.Code != linker.StatusForbidden || e.Message != "denied" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = s.Call(ctx, id, "/unknown", nil)
+	if e, ok := err.(*linker.Error); !ok || e.Code != linker.StatusNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := s.Call(ctx, "missing", "/status", nil); err != linker.ErrNodeNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/wpajqz/linker"
+)
+
+type (
+	// Balancer 为每次请求从健康的地址中选择一个, endpoints不会为空.
+	// header是这次请求通过export.WithHeader设置的属性, 通过Session取连接时为nil
+	Balancer interface {
+		Pick(endpoints []*Endpoint, header linker.Header) *Endpoint
+	}
+
+	roundRobin struct {
+		next uint64
+	}
+
+	leastOutstanding struct {
+		roundRobin
+	}
+
+	powerOfTwoChoices struct{}
+
+	consistentHash struct {
+		property string
+		fallback roundRobin
+	}
+)
+
+// RoundRobin 依次使用每个地址
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+// LeastOutstanding 选择正在处理的请求最少的地址, 相同时依次使用
+func LeastOutstanding() Balancer {
+	return &leastOutstanding{}
+}
+
+// PowerOfTwoChoices 随机选择两个地址, 使用正在处理的请求较少的一个
+func PowerOfTwoChoices() Balancer {
+	return powerOfTwoChoices{}
+}
+
+// ConsistentHash 按照请求属性property的值选择地址, 相同的值总是落在同一个地址上,
+// 地址增减时只有少部分值会改变地址. 请求没有该属性时依次使用每个地址
+func ConsistentHash(property string) Balancer {
+	return &consistentHash{property: property}
+}
+
+func (b *roundRobin) Pick(endpoints []*Endpoint, header linker.Header) *Endpoint {
+	n := atomic.AddUint64(&b.next, 1) - 1
+
+	return endpoints[n%uint64(len(endpoints))]
+}
+
+func (b *leastOutstanding) Pick(endpoints []*Endpoint, header linker.Header) *Endpoint {
+	start := b.roundRobin.Pick(endpoints, header)
+
+	picked := start
+	for _, e := range endpoints {
+		if e.Outstanding() < picked.Outstanding() {
+			picked = e
+		}
+	}
+
+	return picked
+}
+
+func (powerOfTwoChoices) Pick(endpoints []*Endpoint, header linker.Header) *Endpoint {
+	if len(endpoints) == 1 {
+		return endpoints[0]
+	}
+
+	i := rand.Intn(len(endpoints))
+	j := rand.Intn(len(endpoints) - 1)
+	if j >= i {
+		j++
+	}
+
+	if endpoints[j].Outstanding() < endpoints[i].Outstanding() {
+		return endpoints[j]
+	}
+
+	return endpoints[i]
+}
+
+// Pick 使用rendezvous hashing, 每个地址和属性值计算一个分数, 选择分数最高的地址
+func (b *consistentHash) Pick(endpoints []*Endpoint, header linker.Header) *Endpoint {
+	key := header.Get(b.property)
+	if key == "" {
+		return b.fallback.Pick(endpoints, header)
+	}
+
+	var (
+		picked *Endpoint
+		max    uint64
+	)
+
+	for _, e := range endpoints {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(e.address))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(key))
+
+		if score := mix(h.Sum64()); picked == nil || score > max {
+			picked, max = e, score
+		}
+	}
+
+	return picked
+}
+
+// mix 打散fnv的结果, 相近的输入得到的分数也不相关
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
@@ -0,0 +1,152 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker/client/export"
+)
+
+func TestClientBalancer(t *testing.T) {
+	s1, a1 := runServer(t, whoRouter("s1"))
+	s2, a2 := runServer(t, whoRouter("s2"))
+	defer s2.Shutdown(context.Background())
+
+	who := func(c *client.Client, opts ...export.CallOption) (string, error) {
+		var v string
+		err := c.Call(context.Background(), "/who", nil, &v, opts...)
+
+		return v, err
+	}
+
+	c, err := client.NewClient([]string{a1, a2}, client.InitialCapacity(1), client.HealthCheck(1, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		v, err := who(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		counts[v]++
+	}
+
+	if counts["s1"] != 2 || counts["s2"] != 2 {
+		t.Errorf("unexpected round robin: %v", counts)
+	}
+
+	hashed, err := client.NewClient([]string{a1, a2}, client.InitialCapacity(1), client.LoadBalancer(client.ConsistentHash("user")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, user := range []string{"1", "2", "3"} {
+		first, err := who(hashed, export.WithHeader(linker.Header{"user": {user}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			if v, _ := who(hashed, export.WithHeader(linker.Header{"user": {user}})); v != first {
+				t.Errorf("user %s moved from %s to %s", user, first, v)
+			}
+		}
+	}
+
+	// 关闭的地址被摘除, 请求都交给另一个地址
+	if err := s1.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100 && c.Endpoints()[0].Healthy(); i++ {
+		_, _ = who(c)
+	}
+
+	if c.Endpoints()[0].Healthy() {
+		t.Fatal("endpoint not ejected")
+	}
+
+	for i := 0; i < 4; i++ {
+		if v, err := who(c); err != nil || v != "s2" {
+			t.Fatalf("unexpected reply: %s, %v", v, err)
+		}
+	}
+
+	// 探测到地址恢复以后重新使用
+	s3, _ := runServer(t, whoRouter("s1"), linker.WithTCPEndpoint(linker.Endpoint{Address: a1}))
+	defer s3.Shutdown(context.Background())
+
+	for i := 0; i < 100 && !c.Endpoints()[0].Healthy(); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	counts = make(map[string]int)
+	for i := 0; i < 4; i++ {
+		v, err := who(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		counts[v]++
+	}
+
+	if counts["s1"] != 2 || counts["s2"] != 2 {
+		t.Errorf("endpoint not restored: %v", counts)
+	}
+}
+
+func TestClientSessionOutstanding(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	router := linker.NewRouter()
+	router.Route("/block", linker.HandlerFunc(func(ctx linker.Context) {
+		started <- struct{}{}
+		<-release
+		ctx.Success(nil)
+	}))
+
+	s, address := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	c, err := client.NewClient([]string{address}, client.InitialCapacity(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	session, err := c.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 直接使用连接发送的请求同样计入地址正在处理的请求数
+	done := make(chan struct{})
+	if err := session.AsyncSend("/block", nil, client.RequestStatusCallback{
+		End: func() { close(done) },
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	<-started
+	if n := c.Endpoints()[0].Outstanding(); n != 1 {
+		t.Errorf("unexpected outstanding: %d", n)
+	}
+
+	close(release)
+	<-done
+
+	for i := 0; i < 100 && c.Endpoints()[0].Outstanding() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := c.Endpoints()[0].Outstanding(); n != 0 {
+		t.Errorf("unexpected outstanding after reply: %d", n)
+	}
+}
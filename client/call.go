@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+
+	"github.com/wpajqz/linker/client/export"
+)
+
+// Future Go发起的异步调用, Done关闭以后可以读取结果
+type Future struct {
+	done chan struct{}
+	err  error
+}
+
+// Call 按照负载均衡策略选择地址发送请求, 把回复解码到resp, 服务端回复错误时返回*linker.Error.
+// 连接出错的请求由连接的Tracker计入地址的失败次数
+func (c *Client) Call(ctx context.Context, operator string, req, resp interface{}, opts ...export.CallOption) error {
+	e, err := c.pick(export.RequestHeader(opts...))
+	if err != nil {
+		return err
+	}
+
+	session, err := e.session()
+	if err != nil {
+		e.fail()
+		return err
+	}
+
+	return session.Call(ctx, operator, req, resp, opts...)
+}
+
+// Go 异步发送请求, 调用结束以后resp中是解码的回复
+func (c *Client) Go(ctx context.Context, operator string, req, resp interface{}, opts ...export.CallOption) *Future {
+	f := &Future{done: make(chan struct{})}
+
+	go func() {
+		defer close(f.done)
+		f.err = c.Call(ctx, operator, req, resp, opts...)
+	}()
+
+	return f
+}
+
+// ContentType 请求和回复使用的编码
+func (c *Client) ContentType() string {
+	return c.options.contentType
+}
+
+// Done 调用结束时关闭
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait 等待调用结束并返回错误
+func (f *Future) Wait() error {
+	<-f.done
+
+	return f.err
+}
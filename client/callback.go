@@ -2,9 +2,10 @@ package client
 
 type (
 	ReadyStateCallback struct {
-		Open  func()
-		Close func()
-		Error func(err string)
+		Open        func()
+		Close       func()
+		Error       func(err string)
+		StateChange func(from, to int)
 	}
 
 	RequestStatusCallback struct {
@@ -33,6 +34,12 @@ func (r *ReadyStateCallback) OnError(err string) {
 	}
 }
 
+func (r *ReadyStateCallback) OnStateChange(from, to int) {
+	if r.StateChange != nil {
+		r.StateChange(from, to)
+	}
+}
+
 func (r RequestStatusCallback) OnStart() {
 	if r.Start != nil {
 		r.Start()
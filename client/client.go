@@ -1,11 +1,10 @@
 package client
 
 import (
-	"context"
-	"fmt"
+	"errors"
+	"sync"
 	"time"
 
-	"github.com/silenceper/pool"
 	"github.com/wpajqz/linker"
 	"github.com/wpajqz/linker/client/export"
 	"github.com/wpajqz/linker/codec"
@@ -16,75 +15,176 @@ var (
 	defaultNetwork = linker.NetworkTCP
 )
 
+// ErrNoAvailableEndpoint 所有的地址都已经被摘除
+var ErrNoAvailableEndpoint = errors.New("brpc error: no address available")
+
 type (
 	Client struct {
-		options          options
-		clientPool       pool.Pool
-		address          []string
-		availableAddress chan string
+		options   options
+		mutex     sync.RWMutex
+		endpoints []*Endpoint
+		closed    bool
 	}
 )
 
 func NewClient(address []string, opts ...Option) (*Client, error) {
 	options := options{
-		network:     defaultNetwork,
-		contentType: codec.JSON,
-		udpPayload:  4096,
-		dialTimeout: 3 * time.Second,
-		initialCap:  10,
-		maxCap:      30,
+		network:       defaultNetwork,
+		contentType:   codec.JSON,
+		udpPayload:    4096,
+		dialTimeout:   3 * time.Second,
+		initialCap:    10,
+		maxCap:        30,
+		balancer:      RoundRobin(),
+		maxFailures:   3,
+		probeInterval: 2 * time.Second,
 	}
 
 	for _, o := range opts {
 		o(&options)
 	}
 
-	defaultClient = &Client{
-		options:          options,
-		address:          address,
-		availableAddress: make(chan string, len(address)),
+	c := &Client{options: options}
+	c.update(address)
+
+	// 建立连接失败的地址先摘除, 探测到恢复以后再使用
+	var err error
+	for _, e := range c.Endpoints() {
+		if _, err = e.session(); err != nil {
+			e.eject()
+		}
 	}
 
-	defaultClient.fillAddress(address)
+	// 设置了Resolver时地址可以稍后推送
+	if r := options.resolver; r != nil {
+		if err := r.Resolve(c.update); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	} else if len(c.healthy()) == 0 {
+		if err == nil {
+			err = ErrNoAvailableEndpoint
+		}
+
+		_ = c.Close()
 
-	p, err := defaultClient.newExportPool()
-	if err != nil {
 		return nil, err
 	}
 
-	defaultClient.clientPool = p
+	defaultClient = c
 
-	return defaultClient, err
+	return defaultClient, nil
 }
 
 func Session() (*export.Client, error) {
 	return defaultClient.Session()
 }
 
+// Session 按照负载均衡策略选择一个地址, 从它的连接池中取一个连接.
+// 连接上的请求和流同样计入地址正在处理的请求数和健康状态
 func (c *Client) Session() (*export.Client, error) {
-	v, err := c.clientPool.Get()
+	e, err := c.pick(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := e.session()
 	if err != nil {
+		e.fail()
 		return nil, err
 	}
-	defer c.clientPool.Put(v)
 
-	return v.(*export.Client), nil
+	return session, nil
 }
 
-func (c *Client) fillAddress(address []string) {
-	for _, v := range address {
-		defaultClient.availableAddress <- v
+// Endpoints 返回所有的地址, 包括已经摘除的
+func (c *Client) Endpoints() []*Endpoint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return append([]*Endpoint(nil), c.endpoints...)
+}
+
+// update 使用新的完整地址列表, 新的地址加入, 不在列表中的地址关闭连接池
+func (c *Client) update(addresses []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	current := make(map[string]*Endpoint, len(c.endpoints))
+	for _, e := range c.endpoints {
+		current[e.address] = e
 	}
+
+	seen := make(map[string]bool, len(addresses))
+	endpoints := make([]*Endpoint, 0, len(addresses))
+	for _, address := range addresses {
+		// 重复的地址只保留一个
+		if seen[address] {
+			continue
+		}
+
+		seen[address] = true
+
+		e, ok := current[address]
+		if !ok {
+			e = newEndpoint(c, address)
+		}
+
+		endpoints = append(endpoints, e)
+	}
+
+	for address, e := range current {
+		if !seen[address] {
+			e.close()
+		}
+	}
+
+	c.endpoints = endpoints
+}
+
+// Close 停止解析地址并关闭所有的连接池
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	endpoints := c.endpoints
+	c.endpoints, c.closed = nil, true
+	c.mutex.Unlock()
+
+	for _, e := range endpoints {
+		e.close()
+	}
+
+	if r := c.options.resolver; r != nil {
+		return r.Close()
+	}
+
+	return nil
 }
 
-func (c *Client) getAddress() (string, error) {
-	ctx, _ := context.WithTimeout(context.Background(), c.options.dialTimeout)
-	for {
-		select {
-		case addr := <-c.availableAddress:
-			return addr, nil
-		case <-ctx.Done():
-			return "", fmt.Errorf("brpc error: no address available %s\n", ctx.Err())
+// healthy 可以使用的地址
+func (c *Client) healthy() []*Endpoint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	endpoints := make([]*Endpoint, 0, len(c.endpoints))
+	for _, e := range c.endpoints {
+		if e.Healthy() {
+			endpoints = append(endpoints, e)
 		}
 	}
+
+	return endpoints
+}
+
+// pick 使用负载均衡策略从可以使用的地址中选择一个
+func (c *Client) pick(header linker.Header) (*Endpoint, error) {
+	endpoints := c.healthy()
+	if len(endpoints) == 0 {
+		return nil, ErrNoAvailableEndpoint
+	}
+
+	return c.options.balancer.Pick(endpoints, header), nil
 }
@@ -0,0 +1,74 @@
+package client_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker/plugin/crypt"
+)
+
+// runServer 在随机端口上启动服务, 返回监听的地址
+func runServer(t *testing.T, router *linker.Router, opts ...linker.Option) (*linker.Server, string) {
+	t.Helper()
+
+	opts = append([]linker.Option{linker.WithTCPEndpoint(linker.Endpoint{Address: "127.0.0.1:0"})}, opts...)
+	s := linker.NewServer(opts...)
+	s.BindRouter(router)
+
+	go s.Run()
+
+	for i := 0; i < 100; i++ {
+		if addrs := s.Addrs(); len(addrs) > 0 {
+			return s, addrs[0].String()
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("server is not listening")
+	return nil, ""
+}
+
+func whoRouter(name string) *linker.Router {
+	router := linker.NewRouter()
+	router.Route("/who", linker.HandlerFunc(func(ctx linker.Context) {
+		ctx.Success(name)
+	}))
+
+	return router
+}
+
+func TestClientPlugins(t *testing.T) {
+	s, address := runServer(t, whoRouter("s1"),
+		linker.PluginForPacketSender(crypt.NewEncryptPlugin()),
+		linker.PluginForPacketReceiver(crypt.NewDecryptPlugin()),
+	)
+	defer s.Shutdown(context.Background())
+
+	// 握手请求也需要加密, 否则服务端解密失败以后关闭连接
+	c, err := client.NewClient([]string{address}, client.InitialCapacity(1),
+		client.PluginForPacketSender(crypt.NewEncryptPlugin()),
+		client.PluginForPacketReceiver(crypt.NewDecryptPlugin()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	session, err := c.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.Protocol() != linker.ProtocolV2 {
+		t.Errorf("expected protocol v2, got %d", session.Protocol())
+	}
+
+	var v string
+	if err := c.Call(context.Background(), "/who", nil, &v); err != nil || v != "s1" {
+		t.Errorf("unexpected reply: %s, %v", v, err)
+	}
+}
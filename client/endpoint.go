@@ -0,0 +1,190 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/silenceper/pool"
+	"github.com/wpajqz/linker/client/export"
+)
+
+var errEndpointClosed = errors.New("brpc error: address removed")
+
+// Endpoint 一个服务端地址, 每个地址有自己的连接池和健康状态.
+// 连续失败达到HealthCheck设置的次数以后暂时不再使用, 探测到地址恢复以后重新加入
+type Endpoint struct {
+	address     string
+	client      *Client
+	outstanding int64
+	mutex       sync.Mutex
+	pool        pool.Pool
+	failures    int
+	ejected     bool
+	closed      bool
+	done        chan struct{}
+}
+
+func newEndpoint(c *Client, address string) *Endpoint {
+	return &Endpoint{address: address, client: c, done: make(chan struct{})}
+}
+
+// Address 服务端地址
+func (e *Endpoint) Address() string {
+	return e.address
+}
+
+// Outstanding 正在处理的请求数
+func (e *Endpoint) Outstanding() int {
+	return int(atomic.LoadInt64(&e.outstanding))
+}
+
+// Healthy 地址是否可以使用
+func (e *Endpoint) Healthy() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return !e.ejected
+}
+
+// session 从地址的连接池中取一个连接, 第一次使用或者恢复以后才建立连接池
+func (e *Endpoint) session() (*export.Client, error) {
+	e.mutex.Lock()
+	if e.closed {
+		e.mutex.Unlock()
+		return nil, errEndpointClosed
+	}
+
+	if e.pool == nil {
+		p, err := e.client.newExportPool(e.address, export.Tracker(e.track))
+		if err != nil {
+			e.mutex.Unlock()
+			return nil, err
+		}
+
+		e.pool = p
+	}
+
+	p := e.pool
+	e.mutex.Unlock()
+
+	v, err := p.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer p.Put(v)
+
+	return v.(*export.Client), nil
+}
+
+// track 统计经过这个地址连接的请求, 连接出错的请求计入失败次数
+func (e *Endpoint) track() func(err error) {
+	atomic.AddInt64(&e.outstanding, 1)
+
+	return func(err error) {
+		atomic.AddInt64(&e.outstanding, -1)
+
+		if err != nil {
+			e.fail()
+		} else {
+			e.succeed()
+		}
+	}
+}
+
+// succeed 请求成功, 重新开始计算连续失败的次数
+func (e *Endpoint) succeed() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.failures = 0
+}
+
+// fail 连接失败, 连续失败达到上限时摘除地址
+func (e *Endpoint) fail() {
+	e.mutex.Lock()
+	e.failures++
+	failures := e.failures
+	e.mutex.Unlock()
+
+	if failures >= e.client.options.maxFailures {
+		e.eject()
+	}
+}
+
+// eject 摘除地址并开始探测
+func (e *Endpoint) eject() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.ejected || e.closed {
+		return
+	}
+
+	e.ejected = true
+
+	// 连接池中剩下的连接已经不可用, 恢复以后重新建立
+	if e.pool != nil {
+		e.pool.Release()
+		e.pool = nil
+	}
+
+	go e.probe()
+}
+
+// probe 定时发送心跳, 服务端回复以后地址重新加入. udp不需要建立连接, 只有心跳能确认服务端可用
+func (e *Endpoint) probe() {
+	ticker := time.NewTicker(e.client.options.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.heartbeat(); err != nil {
+				continue
+			}
+
+			e.mutex.Lock()
+			e.ejected, e.failures = false, 0
+			e.mutex.Unlock()
+
+			return
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// heartbeat 建立一个单独的连接发送心跳, 不经过连接池
+func (e *Endpoint) heartbeat() error {
+	ec, err := e.client.dial(e.address, &ReadyStateCallback{})
+	if err != nil {
+		return err
+	}
+	defer ec.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.client.options.dialTimeout)
+	defer cancel()
+
+	return ec.Heartbeat(ctx)
+}
+
+// close 地址已经移除, 关闭连接池并停止探测
+func (e *Endpoint) close() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.closed {
+		return
+	}
+
+	e.closed = true
+	close(e.done)
+
+	if e.pool != nil {
+		e.pool.Release()
+		e.pool = nil
+	}
+}
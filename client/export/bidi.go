@@ -0,0 +1,180 @@
+package export
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/codec"
+)
+
+type (
+	// Stream 双向流, Send和Recv可以在不同的goroutine中同时调用.
+	// 双方的发送都受窗口限制, 对方处理得慢时Send会阻塞
+	Stream struct {
+		reader   *StreamReader
+		window   *window
+		sendMu   sync.Mutex
+		sendDone bool
+	}
+
+	// window 发送窗口, 额度用完以后阻塞直到服务端归还
+	window struct {
+		mutex   sync.Mutex
+		credits int
+		wake    chan struct{}
+	}
+)
+
+// OpenStream 打开到operator的双向流, 需要服务端支持v2协议
+func (c *Client) OpenStream(operator string) (*Stream, error) {
+	if err := c.ready("OpenStream"); err != nil {
+		return nil, err
+	}
+
+	if c.Protocol() < linker.ProtocolV2 {
+		return nil, errStreamNotSupported
+	}
+
+	s := &Stream{
+		reader: c.newStreamReader(operator),
+		window: &window{credits: linker.StreamWindow, wake: make(chan struct{}, 1)},
+	}
+
+	p, err := c.newPacket(s.reader.operator, s.reader.sequence, c.requestHeader(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Flags |= linker.FlagStream | linker.FlagOpenStream
+
+	c.handlerContainer.Store(s.reader.listener(), s)
+	if err := s.reader.send(p); err != nil {
+		s.reader.finish(err)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Stream) handlePacket(p linker.Packet, header linker.Header) {
+	if p.Flags&linker.FlagControl != 0 {
+		if header.Get("control") == "window" {
+			if n, err := strconv.Atoi(header.Get("credit")); err == nil && n > 0 {
+				s.window.release(n)
+			}
+		}
+
+		return
+	}
+
+	s.reader.handlePacket(p, header)
+}
+
+// Send 发送一个消息, 窗口用完时等待服务端处理
+func (s *Stream) Send(v interface{}) error {
+	c := s.reader.client
+
+	coder, err := codec.NewCoder(c.contentType)
+	if err != nil {
+		return err
+	}
+
+	body, err := coder.Encoder(v)
+	if err != nil {
+		return err
+	}
+
+	if err := s.window.acquire(s.reader.finished); err != nil {
+		return err
+	}
+
+	p, err := c.newPacket(s.reader.operator, s.reader.sequence, nil, body)
+	if err != nil {
+		return err
+	}
+
+	p.Flags |= linker.FlagStream
+
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	if s.sendDone {
+		return errors.New("send on closed stream")
+	}
+
+	return s.reader.send(p)
+}
+
+// CloseSend 结束发送, 服务端的Recv返回io.EOF, 仍然可以继续Recv
+func (s *Stream) CloseSend() error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	if s.sendDone {
+		return nil
+	}
+
+	s.sendDone = true
+
+	c := s.reader.client
+	p, err := c.newPacket(s.reader.operator, s.reader.sequence, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	p.Flags |= linker.FlagStream | linker.FlagEndOfStream
+
+	return s.reader.send(p)
+}
+
+// Recv 接收服务端的下一个消息, 服务端正常结束时返回io.EOF
+func (s *Stream) Recv(v interface{}) error {
+	if !s.reader.Next() {
+		if err := s.reader.Err(); err != nil {
+			return err
+		}
+
+		return io.EOF
+	}
+
+	return s.reader.Decode(v)
+}
+
+// Close 关闭流, 服务端还没有结束时通知它取消处理
+func (s *Stream) Close() error {
+	return s.reader.Close()
+}
+
+// acquire 获取一个发送额度, done关闭时返回错误
+func (w *window) acquire(done <-chan struct{}) error {
+	for {
+		w.mutex.Lock()
+		if w.credits > 0 {
+			w.credits--
+			w.mutex.Unlock()
+			return nil
+		}
+		w.mutex.Unlock()
+
+		select {
+		case <-w.wake:
+		case <-done:
+			return errors.New("stream closed")
+		}
+	}
+}
+
+// release 服务端归还额度
+func (w *window) release(n int) {
+	w.mutex.Lock()
+	w.credits += n
+	w.mutex.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,83 @@
+package export
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/codec"
+)
+
+type (
+	// CallHandler 处理服务端通过Server.Call发起的请求, 返回值按照ContentType编码以后作为回复.
+	// 返回*linker.Error时回复对应的状态码, 其它错误回复StatusInternalServerError
+	CallHandler interface {
+		ServeCall(header linker.Header, body []byte) (interface{}, error)
+	}
+
+	CallHandlerFunc func(header linker.Header, body []byte) (interface{}, error)
+)
+
+func (f CallHandlerFunc) ServeCall(header linker.Header, body []byte) (interface{}, error) {
+	return f(header, body)
+}
+
+// HandleCall 注册处理服务端请求的处理器, handler为nil时移除
+func (c *Client) HandleCall(operator string, handler CallHandler) {
+	nType := c.operators.Operator(operator)
+	if handler == nil {
+		c.callHandlers.Delete(nType)
+		return
+	}
+
+	c.callHandlers.Store(nType, handler)
+}
+
+// serveCall 在单独的goroutine中处理服务端的请求, 使用同样的operator和sequence回复
+func (c *Client) serveCall(p linker.Packet, header linker.Header) {
+	reply := make(linker.Header)
+
+	body, err := c.handleCall(p, header)
+	if err != nil {
+		e, ok := err.(*linker.Error)
+		if !ok {
+			e = &linker.Error{Code: linker.StatusInternalServerError, Message: err.Error()}
+		}
+
+		reply.Set("code", strconv.Itoa(e.Code))
+		reply.Set("message", e.Message)
+	}
+
+	rp, err := c.newPacket(p.Operator, p.Sequence, reply, body)
+	if err != nil {
+		fmt.Printf("reply call error: %s\n", err.Error())
+		return
+	}
+
+	_ = c.send(rp)
+}
+
+func (c *Client) handleCall(p linker.Packet, header linker.Header) (body []byte, err error) {
+	v, ok := c.callHandlers.Load(p.Operator)
+	if !ok {
+		return nil, &linker.Error{Code: linker.StatusNotFound, Message: linker.StatusText(linker.StatusNotFound)}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			body, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+
+	result, err := v.(CallHandler).ServeCall(header, p.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	coder, err := codec.NewCoder(c.contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	return coder.Encoder(result)
+}
@@ -8,31 +8,35 @@ import (
 	"time"
 
 	"github.com/wpajqz/linker"
-	"github.com/wpajqz/linker/utils/convert"
 	"golang.org/x/sync/errgroup"
 )
 
-// handleConnection 处理客户端连接
-func (c *Client) handleConnection(network string, conn net.Conn) {
+// handleConnection 处理客户端连接, 读取的数据包按照握手协商的protocol解析
+func (c *Client) handleConnection(network string, conn net.Conn, protocol uint8) {
 	eg, ctx := errgroup.WithContext(context.Background())
 
 	eg.Go(func() error {
 		var err error
 
 		switch network {
-		case linker.NetworkTCP:
-			err = c.handleReceivedTCPPackets(conn)
+		case linker.NetworkTCP, linker.NetworkUnix:
+			err = c.handleReceivedTCPPackets(conn, protocol)
 		case linker.NetworkUDP:
-			err = c.handleReceivedUDPPackets(conn)
+			err = c.handleReceivedUDPPackets(conn, protocol)
 		default:
-			panic(fmt.Sprintf("unsupported network, must be %s or %s", linker.NetworkTCP, linker.NetworkUDP))
+			panic(fmt.Sprintf("unsupported network, must be %s, %s or %s", linker.NetworkTCP, linker.NetworkUDP, linker.NetworkUnix))
 		}
 
 		return err
 	})
 
 	eg.Go(func() error {
-		return c.handleSendPackets(ctx, conn)
+		err := c.handleSendPackets(ctx, conn)
+
+		// 写失败时读循环还阻塞在连接上
+		_ = conn.Close()
+
+		return err
 	})
 
 	// wait one second for receive and send routine loaded
@@ -40,13 +44,30 @@ func (c *Client) handleConnection(network string, conn net.Conn) {
 	go c.readyStateCallback.OnOpen()
 
 	err := eg.Wait()
+
+	// 先修改状态, 因为连接断开而失败的请求返回时状态已经不是OPEN
+	policy := c.reconnectSettings()
+	reconnect := policy != nil && !c.closed()
+	if reconnect {
+		c.setReadyState(RECONNECTING)
+	} else {
+		c.setReadyState(CLOSED)
+	}
+
+	c.connectionLost()
+
 	if err != nil {
-		c.readyState = CLOSED
 		if err == io.EOF {
 			c.readyStateCallback.OnClose()
 		} else {
 			c.readyStateCallback.OnError(err.Error())
 		}
+
+		if reconnect {
+			go c.reconnect(*policy)
+			return
+		}
+
 		_ = c.Close()
 	}
 }
@@ -55,14 +76,21 @@ func (c *Client) handleConnection(network string, conn net.Conn) {
 func (c *Client) handleSendPackets(ctx context.Context, conn net.Conn) error {
 	for {
 		select {
-		case p := <-c.packet:
-			_, err := conn.Write(p.Bytes())
+		case o := <-c.packet:
+			// 连接断开之前的请求已经返回错误, 不在新的连接上发送
+			select {
+			case <-o.lost:
+				continue
+			default:
+			}
+
+			_, err := conn.Write(o.packet.Bytes())
 			if err != nil {
 				return err
 			}
 
-			if c.timeout != 0 {
-				err := conn.SetWriteDeadline(time.Now().Add(c.timeout))
+			if timeout := c.settings().timeout; timeout != 0 {
+				err := conn.SetWriteDeadline(time.Now().Add(timeout))
 				if err != nil {
 					return err
 				}
@@ -74,113 +102,108 @@ func (c *Client) handleSendPackets(ctx context.Context, conn net.Conn) error {
 }
 
 // handleReceivedUDPPackets 对接收到的数据包进行处理
-func (c *Client) handleReceivedUDPPackets(conn net.Conn) error {
-	udpConn := conn.(*net.UDPConn)
+func (c *Client) handleReceivedUDPPackets(conn net.Conn, protocol uint8) error {
 	for {
-		if c.timeout != 0 {
-			err := conn.SetReadDeadline(time.Now().Add(c.timeout))
+		if timeout := c.settings().timeout; timeout != 0 {
+			err := conn.SetReadDeadline(time.Now().Add(timeout))
 			if err != nil {
 				return err
 			}
 		}
 
-		var data = make([]byte, c.udpPayload)
-		n, _, err := udpConn.ReadFromUDP(data)
+		p, err := c.readPacket(conn, protocol)
 		if err != nil {
 			continue
 		}
 
-		bType := data[0:4]
-		bSequence := data[4:12]
-		bHeaderLength := data[12:16]
-
-		sequence := convert.BytesToInt64(bSequence)
-		headerLength := convert.BytesToUint32(bHeaderLength)
-
-		header := data[20 : 20+headerLength]
-		body := data[20+headerLength : n]
-
-		receive, err := linker.NewPacket(convert.BytesToUint32(bType), sequence, header, body, c.pluginForPacketReceiver)
-		if err != nil {
+		if err := c.handleReceivedPacket(p); err != nil {
 			return err
 		}
-
-		c.response.Header = receive.Header
-		c.response.Body = receive.Body
-
-		operator := int64(convert.BytesToUint32(bType)) + sequence
-		if handler, ok := c.handlerContainer.Load(operator); ok {
-			if v, ok := handler.(Handler); ok {
-				v.Handle(receive.Header, receive.Body)
-			}
-		}
 	}
 }
 
 // handleReceivedTCPPackets 对接收到的数据包进行处理
-func (c *Client) handleReceivedTCPPackets(conn net.Conn) error {
-	var (
-		bType         = make([]byte, 4)
-		bSequence     = make([]byte, 8)
-		bHeaderLength = make([]byte, 4)
-		bBodyLength   = make([]byte, 4)
-		sequence      int64
-		headerLength  uint32
-		bodyLength    uint32
-	)
-
+func (c *Client) handleReceivedTCPPackets(conn net.Conn, protocol uint8) error {
 	for {
-		if c.timeout != 0 {
-			err := conn.SetReadDeadline(time.Now().Add(c.timeout))
+		if timeout := c.settings().timeout; timeout != 0 {
+			err := conn.SetReadDeadline(time.Now().Add(timeout))
 			if err != nil {
 				return err
 			}
 		}
 
-		if n, err := io.ReadFull(conn, bType); err != nil && n != 4 {
-			return err
-		}
-
-		if n, err := io.ReadFull(conn, bSequence); err != nil && n != 8 {
-			return err
-		}
-
-		if n, err := io.ReadFull(conn, bHeaderLength); err != nil && n != 4 {
+		p, err := c.readPacket(conn, protocol)
+		if err != nil {
 			return err
 		}
 
-		if n, err := io.ReadFull(conn, bBodyLength); err != nil && n != 4 {
+		if err := c.handleReceivedPacket(p); err != nil {
 			return err
 		}
+	}
+}
 
-		nType := convert.BytesToUint32(bType)
-		sequence = convert.BytesToInt64(bSequence)
-		headerLength = convert.BytesToUint32(bHeaderLength)
-		bodyLength = convert.BytesToUint32(bBodyLength)
+// readPacket 按照protocol读取下一个数据包, 被截断, 超过限制或者校验失败的udp数据报直接丢弃,
+// 带有不支持的flags的数据包已经完整读取, 同样丢弃
+func (c *Client) readPacket(conn net.Conn, protocol uint8) (linker.Packet, error) {
+	settings := c.settings()
+
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		for {
+			p, err := linker.ReadPacket(conn, protocol, settings.limits)
+			if err == linker.ErrUnsupportedFlags {
+				continue
+			}
 
-		header := make([]byte, headerLength)
-		if n, err := io.ReadFull(conn, header); err != nil && n != int(headerLength) {
-			return err
+			return p, err
 		}
+	}
 
-		body := make([]byte, bodyLength)
-		if n, err := io.ReadFull(conn, body); err != nil && n != int(bodyLength) {
-			return err
+	for {
+		var data = make([]byte, settings.udpPayload)
+		n, _, err := udpConn.ReadFromUDP(data)
+		if err != nil {
+			return linker.Packet{}, err
 		}
 
-		receive, err := linker.NewPacket(nType, sequence, header, body, c.pluginForPacketReceiver)
+		p, err := linker.ParsePacket(data[:n], protocol, settings.limits)
 		if err != nil {
-			return err
+			continue
 		}
 
-		c.response.Header = receive.Header
-		c.response.Body = receive.Body
+		return p, nil
+	}
+}
 
-		operator := int64(nType) + sequence
-		if handler, ok := c.handlerContainer.Load(operator); ok {
-			if v, ok := handler.(Handler); ok {
-				v.Handle(receive.Header, receive.Body)
-			}
+// handleReceivedPacket 把数据包交给等待回复的处理器
+func (c *Client) handleReceivedPacket(p linker.Packet) error {
+	receive, err := linker.NewPacket(p.Operator, p.Sequence, p.Header, p.Body, c.settings().receivers)
+	if err != nil {
+		return err
+	}
+
+	header, err := linker.DecodeHeader(receive.Header, p.Version)
+	if err != nil {
+		return err
+	}
+
+	// 服务端主动发起的请求, 不影响等待回复的处理器
+	if p.Sequence < 0 {
+		go c.serveCall(receive, header)
+		return nil
+	}
+
+	key := listenerKey{operator: p.Operator, sequence: p.Sequence}
+	if handler, ok := c.handlerContainer.Load(key); ok {
+		switch v := handler.(type) {
+		case packetHandler:
+			receive.Version, receive.Flags = p.Version, p.Flags
+			v.handlePacket(receive, header)
+		case Handler:
+			v.Handle(receive.Header, receive.Body)
 		}
 	}
+
+	return nil
 }
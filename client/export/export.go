@@ -1,13 +1,14 @@
 package export
 
 import (
-	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"hash/crc32"
 	"net"
 	"strconv"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/wpajqz/linker"
@@ -17,12 +18,15 @@ import (
 
 // Connection status
 const (
-	CONNECTING = 0 // 连接还没开启
-	OPEN       = 1 // 连接已开启并准备好进行通信
-	CLOSING    = 2 // 连接正在关闭的过程中
-	CLOSED     = 3 // 连接已经关闭，或者连接无法建立
+	CONNECTING   = 0 // 连接还没开启
+	OPEN         = 1 // 连接已开启并准备好进行通信
+	CLOSING      = 2 // 连接正在关闭的过程中
+	CLOSED       = 3 // 连接已经关闭，或者连接无法建立
+	RECONNECTING = 4 // 连接断开以后正在自动重连, 通过SetReconnect开启
 )
 
+var errConnectionClosed = errors.New("connection closed")
+
 // Handler handle the connection
 type Handler interface {
 	Handle(header, body []byte)
@@ -46,21 +50,32 @@ type ReadyStateCallback interface {
 // Client 客户端结构体
 type Client struct {
 	conn                    net.Conn
-	closed                  bool
+	network, address        string
 	udpPayload              int
+	limits                  linker.FrameLimits
+	protocol                uint8
+	checksum                bool
 	readyStateCallback      ReadyStateCallback
-	readyState              int
-	mutex                   *sync.Mutex
+	readyState              int32
+	sequence                int64
 	rwMutex                 *sync.RWMutex
 	timeout                 time.Duration
 	handlerContainer        sync.Map
-	packet                  chan linker.Packet
+	callHandlers            sync.Map
+	listeners               sync.Map
+	packet                  chan outgoing
+	lost                    chan struct{}
+	reconnectPolicy         *ReconnectPolicy
+	tracker                 func() func(err error)
 	pluginForPacketSender   []plugin.PacketPlugin
 	pluginForPacketReceiver []plugin.PacketPlugin
 	contentType             string
-	request, response       struct {
-		Header, Body []byte
-	}
+	operators               linker.OperatorTable
+	tlsConfig               *tls.Config
+	done                    chan struct{}
+	closeOnce               sync.Once
+	// 每个请求都会带上的header, 发送时复制一份, 回复的header只交给发起请求的调用方
+	header linker.Header
 }
 
 type HandlerFunc func(header, body []byte)
@@ -69,20 +84,50 @@ func (f HandlerFunc) Handle(header, body []byte) {
 	f(header, body)
 }
 
-// NewClient 初始化客户端链接
-func NewClient(address string, readyStateCallback ReadyStateCallback) (*Client, error) {
+type (
+	// listenerKey 等待数据包的处理器, 推送消息的sequence为0
+	listenerKey struct {
+		operator uint32
+		sequence int64
+	}
+
+	// responseFunc 接收一个回复, header是这个回复自己的header
+	responseFunc func(p linker.Packet, header linker.Header)
+)
+
+func (f responseFunc) handlePacket(p linker.Packet, header linker.Header) {
+	f(p, header)
+}
+
+// newClient 初始化客户端的状态并应用opts, 由各个网络的构造函数建立连接
+func newClient(readyStateCallback ReadyStateCallback, opts []Option) *Client {
 	c := &Client{
 		readyState:       CONNECTING,
-		mutex:            new(sync.Mutex),
+		udpPayload:       4096,
+		protocol:         linker.ProtocolV1,
 		rwMutex:          new(sync.RWMutex),
-		packet:           make(chan linker.Packet, 1024),
+		packet:           make(chan outgoing, 1024),
 		handlerContainer: sync.Map{},
+		lost:             make(chan struct{}),
+		done:             make(chan struct{}),
 	}
 
+	c.header = make(linker.Header)
+
 	if readyStateCallback != nil {
 		c.readyStateCallback = readyStateCallback
 	}
 
+	for _, o := range opts {
+		o(c)
+	}
+
+	return c
+}
+
+// NewClient 初始化客户端链接
+func NewClient(address string, readyStateCallback ReadyStateCallback, opts ...Option) (*Client, error) {
+	c := newClient(readyStateCallback, opts)
 	c.SetRequestProperty("v", linker.Version)
 
 	err := c.connect("tcp", address)
@@ -93,20 +138,37 @@ func NewClient(address string, readyStateCallback ReadyStateCallback) (*Client,
 	return c, nil
 }
 
-// NewUDPClient 初始化UDP客户端链接
-func NewUDPClient(address string, readyStateCallback ReadyStateCallback) (*Client, error) {
-	c := &Client{
-		readyState:       CONNECTING,
-		mutex:            new(sync.Mutex),
-		rwMutex:          new(sync.RWMutex),
-		packet:           make(chan linker.Packet, 1024),
-		handlerContainer: sync.Map{},
+// NewTLSClient 初始化使用TLS的客户端链接, 服务端要求客户端证书时在config.Certificates中设置
+func NewTLSClient(address string, config *tls.Config, readyStateCallback ReadyStateCallback, opts ...Option) (*Client, error) {
+	c := newClient(readyStateCallback, opts)
+	c.tlsConfig = config
+	c.SetRequestProperty("v", linker.Version)
+
+	err := c.connect("tcp", address)
+	if err != nil {
+		return nil, err
 	}
 
-	if readyStateCallback != nil {
-		c.readyStateCallback = readyStateCallback
+	return c, nil
+}
+
+// NewUnixClient 初始化unix domain socket客户端链接, address为socket文件的路径
+func NewUnixClient(address string, readyStateCallback ReadyStateCallback, opts ...Option) (*Client, error) {
+	c := newClient(readyStateCallback, opts)
+	c.SetRequestProperty("v", linker.Version)
+
+	err := c.connect(linker.NetworkUnix, address)
+	if err != nil {
+		return nil, err
 	}
 
+	return c, nil
+}
+
+// NewUDPClient 初始化UDP客户端链接
+func NewUDPClient(address string, readyStateCallback ReadyStateCallback, opts ...Option) (*Client, error) {
+	c := newClient(readyStateCallback, opts)
+
 	err := c.connect("udp", address)
 	if err != nil {
 		return nil, err
@@ -117,176 +179,256 @@ func NewUDPClient(address string, readyStateCallback ReadyStateCallback) (*Clien
 
 // GetReadyState 获取链接运行状态
 func (c *Client) GetReadyState() int {
-	return c.readyState
+	return int(atomic.LoadInt32(&c.readyState))
+}
+
+// setReadyState 修改连接状态, readyStateCallback实现了StateChangeCallback时通知状态的变化
+func (c *Client) setReadyState(state int) {
+	from := int(atomic.SwapInt32(&c.readyState, int32(state)))
+	if from == state {
+		return
+	}
+
+	if cb, ok := c.readyStateCallback.(StateChangeCallback); ok {
+		cb.OnStateChange(from, state)
+	}
 }
 
 func (c *Client) GetContentType() string {
 	return c.contentType
 }
 
-// Ping 心跳处理，客户端与服务端保持长连接
-func (c *Client) Ping(param interface{}, callback RequestStatusCallback) error {
-	if callback == nil {
-		return errors.New("callback can't be nil")
-	}
+// nextSequence 分配请求的sequence, 同一个连接上单调递增, 不会重复
+func (c *Client) nextSequence() int64 {
+	return atomic.AddInt64(&c.sequence, 1)
+}
 
-	if c.readyState != OPEN {
-		return errors.New("ping getsockopt: connection refuse")
+// track 通知Tracker请求开始, 返回的函数在请求结束时调用, 只有连接不可用时才把错误交给Tracker.
+// 心跳只用来保持连接, 不计入请求
+func (c *Client) track(operator uint32) func(err error) {
+	if c.tracker == nil || operator == linker.OperatorHeartbeat {
+		return func(error) {}
 	}
 
-	sequence := time.Now().UnixNano()
-	listener := int64(linker.OperatorHeartbeat) + sequence
+	end := c.tracker()
 
-	c.handlerContainer.Store(listener, HandlerFunc(func(header, body []byte) {
-		code := c.GetResponseProperty("code")
-		if code != "" {
-			message := c.GetResponseProperty("message")
-			v, _ := strconv.Atoi(code)
-			callback.OnError(v, message)
-		} else {
-			callback.OnSuccess(header, body)
+	return func(err error) {
+		if err != nil && c.GetReadyState() == OPEN {
+			err = nil
 		}
 
-		c.handlerContainer.Delete(listener)
+		end(err)
+	}
+}
+
+// roundTrip 发送请求并等待对应的回复, 返回的header只属于这一次请求.
+// 多个goroutine可以同时在一个连接上调用, ctx结束, 连接断开或者关闭时返回错误
+func (c *Client) roundTrip(ctx context.Context, operator uint32, header linker.Header, body []byte) (linker.Packet, linker.Header, error) {
+	end := c.track(operator)
+
+	p, h, err := c.exchange(ctx, operator, header, body)
+	end(err)
+
+	return p, h, err
+}
+
+// exchange 在连接上完成一次请求和回复
+func (c *Client) exchange(ctx context.Context, operator uint32, header linker.Header, body []byte) (linker.Packet, linker.Header, error) {
+	type response struct {
+		packet linker.Packet
+		header linker.Header
+	}
+
+	key := listenerKey{operator: operator, sequence: c.nextSequence()}
+	reply := make(chan response, 1)
+	lost := c.generation()
+
+	c.handlerContainer.Store(key, responseFunc(func(p linker.Packet, header linker.Header) {
+		reply <- response{packet: p, header: header}
 	}))
+	defer c.handlerContainer.Delete(key)
 
-	coder, err := codec.NewCoder(c.contentType)
+	p, err := c.newPacket(operator, key.sequence, header, body)
 	if err != nil {
-		return err
+		return p, nil, err
 	}
 
-	body, err := coder.Encoder(param)
-	if err != nil {
-		return err
+	if err := c.write(outgoing{packet: p, lost: lost}); err != nil {
+		return p, nil, err
 	}
 
-	p, err := linker.NewPacket(linker.OperatorHeartbeat, sequence, c.request.Header, body, c.pluginForPacketSender)
-	if err != nil {
-		return err
+	select {
+	case r := <-reply:
+		return r.packet, r.header, nil
+	case <-ctx.Done():
+		return p, nil, ctx.Err()
+	case <-lost:
+		return p, nil, errConnectionLost
+	case <-c.done:
+		return p, nil, errConnectionClosed
+	}
+}
+
+// asyncSend 发送请求, 回复在读循环中交给callback, 连接在回复之前断开时callback收到错误
+func (c *Client) asyncSend(operator uint32, header linker.Header, body []byte, callback RequestStatusCallback) error {
+	var once sync.Once
+
+	key := listenerKey{operator: operator, sequence: c.nextSequence()}
+	answered := make(chan struct{})
+	lost := c.generation()
+	end := c.track(operator)
+
+	c.handlerContainer.Store(key, responseFunc(func(p linker.Packet, header linker.Header) {
+		once.Do(func() {
+			close(answered)
+			c.handlerContainer.Delete(key)
+			end(nil)
+
+			handleResponse(callback, p, header)
+			callback.OnEnd()
+		})
+	}))
+
+	p, err := c.newPacket(operator, key.sequence, header, body)
+	if err == nil {
+		err = c.write(outgoing{packet: p, lost: lost})
 	}
 
-	_, err = c.conn.Write(p.Bytes())
 	if err != nil {
+		c.handlerContainer.Delete(key)
+		end(err)
 		return err
 	}
 
-	if c.timeout != 0 {
-		err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
-		if err != nil {
-			return err
+	go func() {
+		err := errConnectionLost
+		select {
+		case <-answered:
+			return
+		case <-lost:
+		case <-c.done:
+			err = errConnectionClosed
 		}
-	}
+
+		once.Do(func() {
+			c.handlerContainer.Delete(key)
+			end(err)
+
+			callback.OnError(linker.StatusServiceUnavailable, err.Error())
+			callback.OnEnd()
+		})
+	}()
 
 	return nil
 }
 
-// SyncSend 向服务端发送请求，同步处理服务端返回结果
-func (c *Client) SyncSend(operator string, param interface{}, callback RequestStatusCallback) error {
-	if callback == nil {
-		return errors.New("callback can't be nil")
+// handleResponse 根据回复的状态调用callback
+func handleResponse(callback RequestStatusCallback, p linker.Packet, header linker.Header) {
+	if code := header.Get("code"); code != "" {
+		v, _ := strconv.Atoi(code)
+		callback.OnError(v, header.Get("message"))
+	} else {
+		callback.OnSuccess(p.Header, p.Body)
 	}
+}
 
-	if c.readyState != OPEN {
-		return errors.New("SyncSend getsockopt: connection refuse")
+// encode 按照ContentType编码请求参数
+func (c *Client) encode(param interface{}) ([]byte, error) {
+	coder, err := codec.NewCoder(c.contentType)
+	if err != nil {
+		return nil, err
 	}
 
-	nType := crc32.ChecksumIEEE([]byte(operator))
-	sequence := time.Now().UnixNano()
-	listener := int64(nType) + sequence
-
-	// 对数据请求的返回状态进行处理,同步阻塞处理机制
-	c.mutex.Lock()
-	quit := make(chan bool)
-
-	callback.OnStart()
-
-	c.handlerContainer.Store(listener, HandlerFunc(func(header, body []byte) {
-		code := c.GetResponseProperty("code")
-		if code != "" {
-			message := c.GetResponseProperty("message")
-			v, _ := strconv.Atoi(code)
-			callback.OnError(v, message)
-		} else {
-			callback.OnSuccess(header, body)
-		}
+	return coder.Encoder(param)
+}
 
-		callback.OnEnd()
+// Ping 心跳处理，客户端与服务端保持长连接
+func (c *Client) Ping(param interface{}, callback RequestStatusCallback) error {
+	if callback == nil {
+		return errors.New("callback can't be nil")
+	}
 
-		c.handlerContainer.Delete(listener)
-		quit <- true
-	}))
+	if err := c.ready("ping"); err != nil {
+		return err
+	}
 
-	coder, err := codec.NewCoder(c.contentType)
+	body, err := c.encode(param)
 	if err != nil {
 		return err
 	}
 
-	body, err := coder.Encoder(param)
-	if err != nil {
+	return c.asyncSend(linker.OperatorHeartbeat, c.requestHeader(), body, callback)
+}
+
+// Heartbeat 发送心跳并等待服务端回复, 用来确认服务端可以正常处理请求
+func (c *Client) Heartbeat(ctx context.Context) error {
+	if err := c.ready("heartbeat"); err != nil {
 		return err
 	}
 
-	p, err := linker.NewPacket(nType, sequence, c.request.Header, body, c.pluginForPacketSender)
+	_, header, err := c.roundTrip(ctx, linker.OperatorHeartbeat, c.requestHeader(), nil)
 	if err != nil {
 		return err
 	}
 
-	c.packet <- p
-	<-quit
-	c.mutex.Unlock()
+	if code := header.Get("code"); code != "" {
+		v, _ := strconv.Atoi(code)
+		return &linker.Error{Code: v, Message: header.Get("message")}
+	}
 
 	return nil
 }
 
-// AsyncSend 向服务端发送请求，异步处理服务端返回结果
-func (c *Client) AsyncSend(operator string, param interface{}, callback RequestStatusCallback) error {
+// SyncSend 向服务端发送请求，同步处理服务端返回结果
+func (c *Client) SyncSend(operator string, param interface{}, callback RequestStatusCallback) error {
+	return c.syncSend(context.Background(), operator, param, c.requestHeader(), callback)
+}
+
+func (c *Client) syncSend(ctx context.Context, operator string, param interface{}, header linker.Header, callback RequestStatusCallback) error {
 	if callback == nil {
 		return errors.New("callback can't be nil")
 	}
 
-	if c.readyState != OPEN {
-		return errors.New("AsyncSend getsockopt: connection refuse")
+	if err := c.ready("SyncSend"); err != nil {
+		return err
 	}
 
-	nType := crc32.ChecksumIEEE([]byte(operator))
-	sequence := time.Now().UnixNano()
+	body, err := c.encode(param)
+	if err != nil {
+		return err
+	}
 
-	listener := int64(nType) + sequence
 	callback.OnStart()
+	defer callback.OnEnd()
 
-	c.handlerContainer.Store(listener, HandlerFunc(func(header, body []byte) {
-		code := c.GetResponseProperty("code")
-		if code != "" {
-			message := c.GetResponseProperty("message")
-			v, _ := strconv.Atoi(code)
-			callback.OnError(v, message)
-		} else {
-			callback.OnSuccess(header, body)
-		}
+	p, h, err := c.roundTrip(ctx, c.operators.Operator(operator), header, body)
+	if err != nil {
+		return err
+	}
 
-		callback.OnEnd()
+	handleResponse(callback, p, h)
 
-		c.handlerContainer.Delete(listener)
-	}))
+	return nil
+}
 
-	coder, err := codec.NewCoder(c.contentType)
-	if err != nil {
-		return err
+// AsyncSend 向服务端发送请求，异步处理服务端返回结果
+func (c *Client) AsyncSend(operator string, param interface{}, callback RequestStatusCallback) error {
+	if callback == nil {
+		return errors.New("callback can't be nil")
 	}
 
-	body, err := coder.Encoder(param)
-	if err != nil {
+	if err := c.ready("AsyncSend"); err != nil {
 		return err
 	}
 
-	p, err := linker.NewPacket(nType, sequence, c.request.Header, body, c.pluginForPacketSender)
+	body, err := c.encode(param)
 	if err != nil {
 		return err
 	}
 
-	c.packet <- p
+	callback.OnStart()
 
-	return nil
+	return c.asyncSend(c.operators.Operator(operator), c.requestHeader(), body, callback)
 }
 
 // AddMessageListener 添加事件监听器
@@ -295,178 +437,215 @@ func (c *Client) AddMessageListener(topic string, callback Handler) error {
 		return errors.New("callback can't be nil")
 	}
 
-	if c.readyState != OPEN {
-		return errors.New("ping getsockopt: connection refuse")
+	if err := c.ready("AddMessageListener"); err != nil {
+		return err
 	}
 
-	sequence := time.Now().UnixNano()
-	listener := int64(linker.OperatorRegisterListener) + sequence
+	// 先注册处理器, 订阅成功以后立刻推送的消息也能收到
+	key := listenerKey{operator: crc32.ChecksumIEEE([]byte(topic))}
+	c.handlerContainer.Store(key, callback)
 
-	// 对数据请求的返回状态进行处理,同步阻塞处理机制
-	c.mutex.Lock()
-	quit := make(chan bool)
+	_, header, err := c.roundTrip(context.Background(), linker.OperatorRegisterListener, c.requestHeader(), []byte(topic))
+	if err == nil && header.Get("code") != "" {
+		err = errors.New(header.Get("message"))
+	}
 
-	var errRequest error
-	c.handlerContainer.Store(listener, HandlerFunc(func(header, body []byte) {
-		code := c.GetResponseProperty("code")
-		if code != "" {
-			message := c.GetResponseProperty("message")
-			errRequest = errors.New(message)
-		} else {
-			c.handlerContainer.Store(int64(crc32.ChecksumIEEE([]byte(topic))), callback)
-		}
+	if err != nil {
+		c.handlerContainer.Delete(key)
+		return err
+	}
 
-		c.handlerContainer.Delete(listener)
-		quit <- true
-	}))
+	// 重连以后重新注册
+	c.listeners.Store(topic, struct{}{})
 
-	p, err := linker.NewPacket(linker.OperatorRegisterListener, sequence, c.request.Header, []byte(topic), c.pluginForPacketSender)
-	if err != nil {
+	return nil
+}
+
+// RemoveMessageListener 移除事件监听器
+func (c *Client) RemoveMessageListener(topic string) error {
+	if err := c.ready("RemoveMessageListener"); err != nil {
 		return err
 	}
 
-	c.packet <- p
-	<-quit
+	_, header, err := c.roundTrip(context.Background(), linker.OperatorRemoveListener, c.requestHeader(), []byte(topic))
+	if err == nil && header.Get("code") != "" {
+		err = errors.New(header.Get("message"))
+	}
 
-	if errRequest != nil {
-		return errRequest
+	if err != nil {
+		return err
 	}
 
-	c.mutex.Unlock()
+	c.listeners.Delete(topic)
+	c.handlerContainer.Delete(listenerKey{operator: crc32.ChecksumIEEE([]byte(topic))})
 
 	return nil
 }
 
 func (c *Client) SetUDPPayload(size int) {
+	c.rwMutex.Lock()
+	defer c.rwMutex.Unlock()
+
 	c.udpPayload = size
 }
 
-func (c *Client) SetContentType(contentType string) {
-	c.contentType = contentType
+// SetFrameLimits 设置接收数据包的大小限制, 小于等于0时使用默认值, maxFrameSize为0时不限制总长度
+func (c *Client) SetFrameLimits(maxHeaderSize, maxBodySize, maxFrameSize int) {
+	c.rwMutex.Lock()
+	defer c.rwMutex.Unlock()
+
+	c.limits = linker.FrameLimits{MaxHeaderSize: maxHeaderSize, MaxBodySize: maxBodySize, MaxFrameSize: maxFrameSize}
 }
 
-// SetPluginForPacketSender 设置发送包需要的插件
-func (c *Client) SetPluginForPacketSender(plugins ...plugin.PacketPlugin) {
-	c.pluginForPacketSender = plugins
+// SetChecksum 使用v2协议时在数据包末尾附加CRC32C校验值, 服务端的回复也会带上校验值
+func (c *Client) SetChecksum(checksum bool) {
+	c.rwMutex.Lock()
+	defer c.rwMutex.Unlock()
+
+	c.checksum = checksum
 }
 
-// pluginForPacketReceiver 设置接收包需要的插件
-func (c *Client) SetPluginForPacketReceiver(plugins ...plugin.PacketPlugin) {
-	c.pluginForPacketReceiver = plugins
+// Protocol 返回和服务端协商的协议版本
+func (c *Client) Protocol() uint8 {
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
+
+	return c.protocol
 }
 
-// RemoveMessageListener 移除事件监听器
-func (c *Client) RemoveMessageListener(topic string) error {
-	if c.readyState != OPEN {
-		return errors.New("ping getsockopt: connection refuse")
+// newPacket 按照协商的协议版本生成数据包, 只有支持v2的服务端才能解析二进制header
+func (c *Client) newPacket(operator uint32, sequence int64, header linker.Header, body []byte) (linker.Packet, error) {
+	return c.buildPacket(c.Protocol(), operator, sequence, header, body)
+}
+
+// buildPacket 按照protocol生成数据包
+func (c *Client) buildPacket(protocol uint8, operator uint32, sequence int64, header linker.Header, body []byte) (linker.Packet, error) {
+	h := header.EncodeLegacy()
+	if protocol >= linker.ProtocolV2 {
+		h = header.Encode()
 	}
 
-	sequence := time.Now().UnixNano()
-	listener := int64(linker.OperatorRemoveListener) + sequence
+	p, err := linker.NewPacket(operator, sequence, h, body, c.pluginForPacketSender)
+	if err != nil {
+		return p, err
+	}
 
-	// 对数据请求的返回状态进行处理,同步阻塞处理机制
-	c.mutex.Lock()
-	quit := make(chan bool)
+	if protocol >= linker.ProtocolV2 {
+		c.rwMutex.RLock()
+		checksum := c.checksum
+		c.rwMutex.RUnlock()
 
-	var errRequest error
-	c.handlerContainer.Store(listener, HandlerFunc(func(header, body []byte) {
-		code := c.GetResponseProperty("code")
-		if code != "" {
-			message := c.GetResponseProperty("message")
-			errRequest = errors.New(message)
-		} else {
-			c.handlerContainer.Delete(int64(crc32.ChecksumIEEE([]byte(topic))))
+		p.Version = protocol
+		if checksum {
+			p.Flags |= linker.FlagChecksum
 		}
+	}
 
-		c.handlerContainer.Delete(listener)
-		quit <- true
-	}))
+	return p, nil
+}
 
-	p, err := linker.NewPacket(linker.OperatorRemoveListener, sequence, c.request.Header, []byte(topic), c.pluginForPacketSender)
-	if err != nil {
-		return err
-	}
+func (c *Client) SetContentType(contentType string) {
+	c.contentType = contentType
+}
 
-	c.packet <- p
-	<-quit
+// SetOperatorTable 设置显式的operator表, 需要和服务端路由使用的表一致
+func (c *Client) SetOperatorTable(table linker.OperatorTable) {
+	c.operators = table
+}
 
-	if errRequest != nil {
-		return errRequest
-	}
+// SetPluginForPacketSender 设置发送包需要的插件
+func (c *Client) SetPluginForPacketSender(plugins ...plugin.PacketPlugin) {
+	c.pluginForPacketSender = plugins
+}
 
-	c.mutex.Unlock()
+// pluginForPacketReceiver 设置接收包需要的插件
+func (c *Client) SetPluginForPacketReceiver(plugins ...plugin.PacketPlugin) {
+	c.rwMutex.Lock()
+	defer c.rwMutex.Unlock()
 
-	return nil
+	c.pluginForPacketReceiver = plugins
+}
+
+// RequestHeader 返回每个请求都会带上的header的副本, 修改它不影响之后的请求
+func (c *Client) RequestHeader() linker.Header {
+	return c.requestHeader()
+}
+
+// requestHeader 发送时使用的header, 和SetRequestProperty并发调用也是安全的
+func (c *Client) requestHeader() linker.Header {
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
+
+	return c.header.Clone()
 }
 
 // SetRequestProperty 设置请求属性
 func (c *Client) SetRequestProperty(key, value string) {
-	v := c.GetRequestProperty(key)
-	old := []byte(key + "=" + v + ";")
-	new := []byte("")
+	c.rwMutex.Lock()
+	defer c.rwMutex.Unlock()
 
-	c.request.Header = bytes.ReplaceAll(c.request.Header, old, new)
-	c.request.Header = append(c.request.Header, []byte(key+"="+value+";")...)
+	c.header.Set(key, value)
 }
 
 // GetRequestProperty 获取请求属性
 func (c *Client) GetRequestProperty(key string) string {
-	values := strings.Split(string(c.request.Header), ";")
-	for _, value := range values {
-		kv := strings.Split(value, "=")
-		if kv[0] == key {
-			return kv[1]
-		}
-	}
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
 
-	return ""
+	return c.header.Get(key)
 }
 
-// GetResponseProperty 获取响应属性
-func (c *Client) GetResponseProperty(key string) string {
-	values := strings.Split(string(c.response.Header), ";")
-	for _, value := range values {
-		kv := strings.Split(value, "=")
-		if kv[0] == key {
-			return kv[1]
-		}
-	}
+// SetTimeout 设置服务端默认超时时间, 单位s
+func (c *Client) SetTimeout(timeout int) {
+	c.rwMutex.Lock()
+	defer c.rwMutex.Unlock()
+
+	c.timeout = time.Duration(timeout) * time.Second
+}
 
-	return ""
+// loopSettings 读写循环使用的设置, 连接建立以后仍然可以通过Set方法修改
+type loopSettings struct {
+	timeout    time.Duration
+	limits     linker.FrameLimits
+	udpPayload int
+	receivers  []plugin.PacketPlugin
 }
 
-// SetResponseProperty 设置响应属性
-func (c *Client) SetResponseProperty(key, value string) {
-	v := c.GetResponseProperty(key)
-	old := []byte(key + "=" + v + ";")
-	new := []byte("")
+func (c *Client) settings() loopSettings {
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
 
-	c.response.Header = bytes.ReplaceAll(c.response.Header, old, new)
-	c.response.Header = append(c.response.Header, []byte(key+"="+value+";")...)
+	return loopSettings{timeout: c.timeout, limits: c.limits, udpPayload: c.udpPayload, receivers: c.pluginForPacketReceiver}
 }
 
-// SetTimeout 设置服务端默认超时时间, 单位s
-func (c *Client) SetTimeout(timeout int) {
-	c.timeout = time.Duration(timeout) * time.Second
+// Done 客户端关闭以后返回的channel被关闭
+func (c *Client) Done() <-chan struct{} {
+	return c.done
 }
 
 // Close 关闭链接
 func (c *Client) Close() error {
-	c.closed = true
+	c.closeOnce.Do(func() { close(c.done) })
+
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
+
 	return c.conn.Close()
 }
 
 func (c *Client) connect(network, address string) error {
-	var err error
+	c.network, c.address = network, address
 
-	c.conn, err = net.Dial(network, address)
+	conn, err := c.dial()
 	if err != nil {
 		return err
 	}
 
-	c.readyState = OPEN
+	if _, err := c.start(conn); err != nil {
+		return err
+	}
 
-	go c.handleConnection(network, c.conn)
+	c.setReadyState(OPEN)
 
 	return nil
 }
@@ -0,0 +1,66 @@
+package export
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/wpajqz/linker"
+)
+
+// 等待握手回复的时间
+const handshakeTimeout = 3 * time.Second
+
+// handshake 在读写循环开始之前和服务端协商协议版本, 之后这个连接上的数据包都按照协商的版本读写.
+// 握手请求使用v1格式, 不支持握手的旧版本服务端回复错误, 这时继续使用v1
+func (c *Client) handshake(conn net.Conn) (uint8, error) {
+	sequence := c.nextSequence()
+	p, err := c.buildPacket(linker.ProtocolV1, linker.OperatorHandshake, sequence, linker.Header{"protocol": {strconv.Itoa(linker.ProtocolV2)}}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return 0, err
+	}
+
+	if _, err := conn.Write(p.Bytes()); err != nil {
+		return 0, err
+	}
+
+	for {
+		rp, err := c.readPacket(conn, linker.ProtocolV1)
+		if err != nil {
+			return 0, err
+		}
+
+		// 握手之前服务端推送的消息
+		if rp.Operator != linker.OperatorHandshake || rp.Sequence != sequence {
+			if err := c.handleReceivedPacket(rp); err != nil {
+				return 0, err
+			}
+
+			continue
+		}
+
+		receive, err := linker.NewPacket(rp.Operator, rp.Sequence, rp.Header, rp.Body, c.settings().receivers)
+		if err != nil {
+			return 0, err
+		}
+
+		header, err := linker.DecodeHeader(receive.Header, rp.Version)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := conn.SetDeadline(time.Time{}); err != nil {
+			return 0, err
+		}
+
+		if v, err := strconv.Atoi(header.Get("protocol")); err == nil && v >= linker.ProtocolV2 {
+			return linker.ProtocolV2, nil
+		}
+
+		return linker.ProtocolV1, nil
+	}
+}
@@ -0,0 +1,63 @@
+package export
+
+import (
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/plugin"
+)
+
+// Option 建立连接之前应用的设置, 握手请求就已经使用这些设置.
+// 加密等插件需要通过Option设置, 连接建立以后再调用Set方法时握手已经按照没有插件的格式发出
+type Option func(*Client)
+
+func UDPPayload(size int) Option {
+	return func(c *Client) {
+		c.udpPayload = size
+	}
+}
+
+// FrameLimits 接收数据包的大小限制, 小于等于0时使用默认值, maxFrameSize为0时不限制总长度
+func FrameLimits(maxHeaderSize, maxBodySize, maxFrameSize int) Option {
+	return func(c *Client) {
+		c.limits = linker.FrameLimits{MaxHeaderSize: maxHeaderSize, MaxBodySize: maxBodySize, MaxFrameSize: maxFrameSize}
+	}
+}
+
+func ContentType(contentType string) Option {
+	return func(c *Client) {
+		c.contentType = contentType
+	}
+}
+
+// Operators 显式的operator表, 需要和服务端路由使用的表一致
+func Operators(table linker.OperatorTable) Option {
+	return func(c *Client) {
+		c.operators = table
+	}
+}
+
+// Reconnect 连接断开以后自动重连的策略
+func Reconnect(policy *ReconnectPolicy) Option {
+	return func(c *Client) {
+		c.SetReconnect(policy)
+	}
+}
+
+// Tracker 每个请求开始时调用begin, 请求结束时调用它返回的函数, 连接出错导致请求失败时err不为nil.
+// 负载均衡通过它统计连接上正在处理的请求和地址的健康状态, 流在结束时才算请求结束
+func Tracker(begin func() func(err error)) Option {
+	return func(c *Client) {
+		c.tracker = begin
+	}
+}
+
+func PluginForPacketSender(plugins ...plugin.PacketPlugin) Option {
+	return func(c *Client) {
+		c.pluginForPacketSender = append(c.pluginForPacketSender, plugins...)
+	}
+}
+
+func PluginForPacketReceiver(plugins ...plugin.PacketPlugin) Option {
+	return func(c *Client) {
+		c.pluginForPacketReceiver = append(c.pluginForPacketReceiver, plugins...)
+	}
+}
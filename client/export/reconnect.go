@@ -0,0 +1,249 @@
+package export
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/wpajqz/linker"
+)
+
+var (
+	// ErrReconnecting 连接断开正在重连, 重连策略不等待时请求直接返回该错误
+	ErrReconnecting = errors.New("connection lost, reconnecting")
+	// errConnectionLost 请求已经发出, 但是连接在回复之前断开
+	errConnectionLost = errors.New("connection lost before reply")
+)
+
+type (
+	// ReconnectPolicy 连接断开以后自动重连的策略.
+	// 第n次重连之前等待MinDelay*Multiplier^n, 最大MaxDelay, 再随机浮动Jitter的比例
+	ReconnectPolicy struct {
+		MinDelay    time.Duration // 第一次重连之前等待的时间, 默认100ms
+		MaxDelay    time.Duration // 等待时间的上限, 默认30s
+		Multiplier  float64       // 每次失败以后等待时间的倍数, 默认2
+		Jitter      float64       // 等待时间随机浮动的比例, 0到1之间, 默认0.2
+		MaxAttempts int           // 连续失败多少次以后放弃并关闭客户端, 0表示一直重连
+		Buffer      bool          // 重连期间的请求等待连接恢复以后发送, 否则直接返回ErrReconnecting
+	}
+
+	// StateChangeCallback ReadyStateCallback同时实现该接口时, 连接状态的每次变化都会通知.
+	// 回调在改变状态的goroutine中同步执行, 不能阻塞
+	StateChangeCallback interface {
+		OnStateChange(from, to int)
+	}
+
+	// outgoing 等待发送的数据包, 所属的连接断开以后不再发送
+	outgoing struct {
+		packet linker.Packet
+		lost   chan struct{}
+	}
+)
+
+// SetReconnect 设置自动重连的策略, 为nil时连接断开以后直接关闭客户端
+func (c *Client) SetReconnect(policy *ReconnectPolicy) {
+	c.rwMutex.Lock()
+	defer c.rwMutex.Unlock()
+
+	if policy == nil {
+		c.reconnectPolicy = nil
+		return
+	}
+
+	p := *policy
+	if p.MinDelay <= 0 {
+		p.MinDelay = 100 * time.Millisecond
+	}
+
+	if p.MaxDelay < p.MinDelay {
+		p.MaxDelay = 30 * time.Second
+		if p.MaxDelay < p.MinDelay {
+			p.MaxDelay = p.MinDelay
+		}
+	}
+
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = 0.2
+	}
+
+	c.reconnectPolicy = &p
+}
+
+func (c *Client) reconnectSettings() *ReconnectPolicy {
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
+
+	return c.reconnectPolicy
+}
+
+// backoff 第attempt次重连之前等待的时间
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	delay := float64(p.MinDelay) * math.Pow(p.Multiplier, float64(attempt))
+	if delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	delay += delay * p.Jitter * (rand.Float64()*2 - 1)
+
+	return time.Duration(delay)
+}
+
+// ready 检查当前状态能否发送请求, name用在错误信息中
+func (c *Client) ready(name string) error {
+	switch c.GetReadyState() {
+	case OPEN:
+		return nil
+	case RECONNECTING:
+		if p := c.reconnectSettings(); p != nil && p.Buffer {
+			return nil
+		}
+
+		return ErrReconnecting
+	}
+
+	return errors.New(name + " getsockopt: connection refuse")
+}
+
+// generation 当前连接的标记, 连接断开时关闭, 之前发出的请求不会再收到回复
+func (c *Client) generation() chan struct{} {
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
+
+	return c.lost
+}
+
+// connectionLost 通知等待当前连接回复的请求, 之后的请求属于下一个连接
+func (c *Client) connectionLost() {
+	c.rwMutex.Lock()
+	lost := c.lost
+	c.lost = make(chan struct{})
+	c.rwMutex.Unlock()
+
+	close(lost)
+}
+
+func (c *Client) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// dial 按照客户端的网络和地址建立连接
+func (c *Client) dial() (net.Conn, error) {
+	if c.tlsConfig != nil && c.network == linker.NetworkTCP {
+		return tls.Dial(c.network, c.address, c.tlsConfig)
+	}
+
+	return net.Dial(c.network, c.address)
+}
+
+// start 在新建立的连接上协商协议版本并开始读写循环, 返回这个连接的标记.
+// 客户端已经关闭时返回errConnectionClosed, 握手失败时关闭连接并返回错误
+func (c *Client) start(conn net.Conn) (chan struct{}, error) {
+	c.rwMutex.Lock()
+	if c.closed() {
+		c.rwMutex.Unlock()
+		_ = conn.Close()
+		return nil, errConnectionClosed
+	}
+
+	c.conn = conn
+	c.rwMutex.Unlock()
+
+	protocol, err := c.handshake(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	c.rwMutex.Lock()
+	// 重连期间按照旧版本编码的请求不能在协商了其它版本的连接上发送
+	if protocol != c.protocol {
+		close(c.lost)
+		c.lost = make(chan struct{})
+	}
+
+	c.protocol = protocol
+	lost := c.lost
+	c.rwMutex.Unlock()
+
+	go c.handleConnection(c.network, conn, protocol)
+
+	return lost, nil
+}
+
+// reconnect 按照策略重新建立连接, 成功以后重新注册所有的事件监听器
+func (c *Client) reconnect(policy ReconnectPolicy) {
+	for attempt := 0; policy.MaxAttempts == 0 || attempt < policy.MaxAttempts; attempt++ {
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-c.done:
+			timer.Stop()
+			c.setReadyState(CLOSED)
+			return
+		}
+
+		conn, err := c.dial()
+		if err != nil {
+			continue
+		}
+
+		lost, err := c.start(conn)
+		if err == errConnectionClosed {
+			c.setReadyState(CLOSED)
+			return
+		}
+
+		if err != nil {
+			continue
+		}
+
+		c.resubscribe()
+
+		// 新的连接也已经断开时, 由它的读写循环继续重连
+		select {
+		case <-lost:
+		default:
+			c.setReadyState(OPEN)
+		}
+
+		return
+	}
+
+	c.setReadyState(CLOSED)
+	c.readyStateCallback.OnError("reconnect: too many attempts")
+	_ = c.Close()
+}
+
+// resubscribe 在新的连接上重新注册通过AddMessageListener添加的主题
+func (c *Client) resubscribe() {
+	c.listeners.Range(func(key, value interface{}) bool {
+		topic := key.(string)
+
+		ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+		defer cancel()
+
+		_, header, err := c.roundTrip(ctx, linker.OperatorRegisterListener, c.requestHeader(), []byte(topic))
+		if err == nil && header.Get("code") != "" {
+			err = errors.New(header.Get("message"))
+		}
+
+		if err != nil {
+			c.readyStateCallback.OnError("resubscribe " + topic + " error: " + err.Error())
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,160 @@
+package export_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker/client/export"
+	"github.com/wpajqz/linker/codec"
+)
+
+// runServer 在随机端口上启动服务, 返回监听的地址
+func runServer(t *testing.T, router *linker.Router, opts ...linker.Option) (*linker.Server, string) {
+	t.Helper()
+
+	opts = append([]linker.Option{linker.WithTCPEndpoint(linker.Endpoint{Address: "127.0.0.1:0"})}, opts...)
+	s := linker.NewServer(opts...)
+	s.BindRouter(router)
+
+	go s.Run()
+
+	for i := 0; i < 100; i++ {
+		if addrs := s.Addrs(); len(addrs) > 0 {
+			return s, addrs[0].String()
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("server is not listening")
+	return nil, ""
+}
+
+func TestClientReconnect(t *testing.T) {
+	newRouter := func() *linker.Router {
+		router := linker.NewRouter()
+		router.Route("/echo", linker.HandlerFunc(func(ctx linker.Context) {
+			var v string
+			if err := ctx.ParseParam(&v); err != nil {
+				ctx.Error(linker.StatusBadRequest, err.Error())
+				return
+			}
+
+			ctx.Success(v)
+		}))
+		router.Route("/publish", linker.HandlerFunc(func(ctx linker.Context) {
+			if err := ctx.Publish("news", string(ctx.RawBody())); err != nil {
+				ctx.Error(linker.StatusInternalServerError, err.Error())
+			}
+		}))
+
+		return router
+	}
+
+	s1, address := runServer(t, newRouter())
+
+	states := make(chan int, 16)
+	c, err := export.NewClient(address, &client.ReadyStateCallback{
+		StateChange: func(from, to int) { states <- to },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.SetContentType(codec.JSON)
+	c.SetReconnect(&export.ReconnectPolicy{MinDelay: 20 * time.Millisecond, MaxDelay: 100 * time.Millisecond, Buffer: true})
+
+	waitState := func(state int) {
+		t.Helper()
+
+		for {
+			select {
+			case v := <-states:
+				if v == state {
+					return
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("state %d not reached, current %d", state, c.GetReadyState())
+			}
+		}
+	}
+
+	waitState(export.OPEN)
+
+	news := make(chan string, 1)
+	if err := c.AddMessageListener("news", export.HandlerFunc(func(header, body []byte) {
+		news <- string(body)
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s1.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	waitState(export.RECONNECTING)
+
+	// 重连期间的请求等待新的连接
+	buffered := make(chan error, 1)
+	go func() {
+		var v string
+		if err := c.Call(context.Background(), "/echo", "buffered", &v); err != nil {
+			buffered <- err
+			return
+		}
+
+		if v != "buffered" {
+			t.Errorf("unexpected reply: %s", v)
+		}
+
+		buffered <- nil
+	}()
+
+	s2, _ := runServer(t, newRouter(), linker.WithTCPEndpoint(linker.Endpoint{Address: address}))
+	defer s2.Shutdown(context.Background())
+
+	waitState(export.OPEN)
+
+	select {
+	case err := <-buffered:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("buffered call not finished")
+	}
+
+	// 监听器在新的连接上重新注册
+	if err := c.Call(context.Background(), "/publish", "hello", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case v := <-news:
+		if !strings.Contains(v, "hello") {
+			t.Errorf("unexpected message: %s", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("listener not registered after reconnect")
+	}
+
+	// 不等待的策略在重连期间直接返回错误
+	c.SetReconnect(&export.ReconnectPolicy{MinDelay: time.Minute})
+	if err := s2.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	waitState(export.RECONNECTING)
+
+	if err := c.Call(context.Background(), "/echo", "x", nil); err != export.ErrReconnecting {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_ = c.Close()
+	waitState(export.CLOSED)
+}
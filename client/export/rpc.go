@@ -0,0 +1,110 @@
+package export
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/codec"
+)
+
+type (
+	// CallOption Call的可选参数
+	CallOption func(*callOptions)
+
+	callOptions struct {
+		header  linker.Header
+		receive *linker.Header
+	}
+)
+
+// WithHeader 这一次请求额外带上的header, 和RequestHeader合并, 不修改客户端共享的header
+func WithHeader(header linker.Header) CallOption {
+	return func(o *callOptions) {
+		o.header = header
+	}
+}
+
+// ReceiveHeader 把这一次请求的回复header保存到h
+func ReceiveHeader(h *linker.Header) CallOption {
+	return func(o *callOptions) {
+		o.receive = h
+	}
+}
+
+// RequestHeader 返回opts通过WithHeader设置的header, 发送之前需要读取请求属性时使用, 比如负载均衡
+func RequestHeader(opts ...CallOption) linker.Header {
+	var options callOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	return options.header
+}
+
+// Call 发送请求并按照ContentType把回复解码到resp, resp为*[]byte时保存原始的body, 为nil时忽略body.
+// 服务端回复错误时返回*linker.Error, ctx的截止时间会传递给服务端
+func (c *Client) Call(ctx context.Context, operator string, req, resp interface{}, opts ...CallOption) error {
+	var options callOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	if err := c.ready("Call"); err != nil {
+		return err
+	}
+
+	body, err := c.encode(req)
+	if err != nil {
+		return err
+	}
+
+	header := c.requestHeader()
+	if len(options.header) > 0 {
+		for k, v := range options.header {
+			header[k] = append([]string(nil), v...)
+		}
+	}
+
+	p, h, err := c.roundTrip(ctx, c.operators.Operator(operator), withDeadline(ctx, header), body)
+	if err != nil {
+		return err
+	}
+
+	if options.receive != nil {
+		*options.receive = h
+	}
+
+	if code := h.Get("code"); code != "" {
+		v, _ := strconv.Atoi(code)
+		return &linker.Error{Code: v, Message: h.Get("message")}
+	}
+
+	switch v := resp.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*v = p.Body
+		return nil
+	}
+
+	coder, err := codec.NewCoder(c.contentType)
+	if err != nil {
+		return err
+	}
+
+	return coder.Decoder(p.Body, resp)
+}
+
+// withDeadline 把ctx的截止时间通过timeout属性传递给服务端, 超时以后服务端可以放弃处理
+func withDeadline(ctx context.Context, header linker.Header) linker.Header {
+	if deadline, ok := ctx.Deadline(); ok {
+		if ms := time.Until(deadline).Milliseconds(); ms > 0 {
+			header = header.Clone()
+			header.Set("timeout", strconv.FormatInt(ms, 10))
+		}
+	}
+
+	return header
+}
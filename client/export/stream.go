@@ -0,0 +1,282 @@
+package export
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/codec"
+)
+
+var (
+	errStreamNotSupported = errors.New("stream requires protocol v2, the server doesn't support it")
+	// errStreamOverflow 服务端超过窗口发送, 流被取消
+	errStreamOverflow = errors.New("stream window exceeded")
+)
+
+type (
+	// packetHandler 需要flags的处理器, 比如流式回复
+	packetHandler interface {
+		handlePacket(p linker.Packet, header linker.Header)
+	}
+
+	// StreamReader 接收服务端的流式回复
+	//
+	//	s, err := c.Stream("/list", param)
+	//	defer s.Close()
+	//	for s.Next() {
+	//		s.Decode(&item)
+	//	}
+	//	err = s.Err()
+	StreamReader struct {
+		client    *Client
+		operator  uint32
+		sequence  int64
+		frames    chan []byte
+		lost      chan struct{}
+		mutex     sync.Mutex
+		err       error
+		consumed  int
+		finished  chan struct{}
+		body      []byte
+		closeOnce sync.Once
+		end       func(err error)
+	}
+)
+
+// Stream 发送请求并接收服务端的流式回复, 需要服务端支持v2协议.
+// 服务端按照窗口发送, Next读取以后归还额度, 不读取时只暂停这个流. 不再需要时调用Close取消
+func (c *Client) Stream(operator string, param interface{}) (*StreamReader, error) {
+	if err := c.ready("Stream"); err != nil {
+		return nil, err
+	}
+
+	if c.Protocol() < linker.ProtocolV2 {
+		return nil, errStreamNotSupported
+	}
+
+	coder, err := codec.NewCoder(c.contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := coder.Encoder(param)
+	if err != nil {
+		return nil, err
+	}
+
+	r := c.newStreamReader(operator)
+
+	p, err := c.newPacket(r.operator, r.sequence, c.requestHeader(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.handlerContainer.Store(r.listener(), r)
+	if err := r.send(p); err != nil {
+		r.finish(err)
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// newStreamReader 创建接收operator流式回复的StreamReader, 流所在的连接断开时结束并返回错误
+func (c *Client) newStreamReader(operator string) *StreamReader {
+	nType := c.operators.Operator(operator)
+	r := &StreamReader{
+		client:   c,
+		operator: nType,
+		sequence: c.nextSequence(),
+		frames:   make(chan []byte, linker.StreamWindow),
+		lost:     c.generation(),
+		finished: make(chan struct{}),
+		end:      c.track(nType),
+	}
+
+	go func() {
+		select {
+		case <-r.lost:
+			r.finish(errConnectionLost)
+		case <-r.finished:
+		}
+	}()
+
+	return r
+}
+
+func (r *StreamReader) listener() listenerKey {
+	return listenerKey{operator: r.operator, sequence: r.sequence}
+}
+
+func (r *StreamReader) handlePacket(p linker.Packet, header linker.Header) {
+	if p.Flags&linker.FlagControl != 0 {
+		return
+	}
+
+	// 服务端没有使用流式回复时, 普通的回复作为唯一的数据
+	if p.Flags&linker.FlagStream != 0 && p.Flags&linker.FlagEndOfStream == 0 {
+		// 在连接的读循环中执行, 不能阻塞. 服务端超过窗口发送时取消流
+		select {
+		case r.frames <- p.Body:
+		case <-r.finished:
+		default:
+			r.finish(errStreamOverflow)
+			go r.control(linker.Header{"control": {"cancel"}})
+		}
+
+		return
+	}
+
+	if code := header.Get("code"); code != "" {
+		v, _ := strconv.Atoi(code)
+		r.finish(&linker.Error{Code: v, Message: header.Get("message")})
+	} else {
+		if p.Flags&linker.FlagStream == 0 {
+			select {
+			case r.frames <- p.Body:
+			case <-r.finished:
+			}
+		}
+
+		r.finish(nil)
+	}
+}
+
+// finish 结束接收, 已经收到的数据仍然可以通过Next读取
+func (r *StreamReader) finish(err error) {
+	r.client.handlerContainer.Delete(r.listener())
+
+	r.mutex.Lock()
+	if r.err == nil {
+		r.err = err
+	}
+	r.mutex.Unlock()
+
+	r.closeOnce.Do(func() {
+		close(r.finished)
+		r.end(err)
+	})
+}
+
+// Next 等待下一个数据, 流结束或者出错时返回false
+func (r *StreamReader) Next() bool {
+	select {
+	case r.body = <-r.frames:
+		r.grant()
+		return true
+	default:
+	}
+
+	select {
+	case r.body = <-r.frames:
+		r.grant()
+		return true
+	case <-r.finished:
+		// 结束之前收到的数据优先返回
+		select {
+		case r.body = <-r.frames:
+			return true
+		default:
+			return false
+		}
+	case <-r.client.done:
+		r.finish(errConnectionClosed)
+		return false
+	}
+}
+
+// grant 处理过半个窗口的消息以后通知服务端继续发送, 通知失败时结束流
+func (r *StreamReader) grant() {
+	r.mutex.Lock()
+	r.consumed++
+	n := r.consumed
+	if n < linker.StreamWindow/2 {
+		r.mutex.Unlock()
+		return
+	}
+
+	r.consumed = 0
+	r.mutex.Unlock()
+
+	if err := r.control(linker.Header{"control": {"window"}, "credit": {strconv.Itoa(n)}}); err != nil {
+		r.finish(err)
+	}
+}
+
+// Body 返回Next得到的数据
+func (r *StreamReader) Body() []byte {
+	return r.body
+}
+
+// Decode 使用客户端的content type解析Next得到的数据
+func (r *StreamReader) Decode(v interface{}) error {
+	coder, err := codec.NewCoder(r.client.contentType)
+	if err != nil {
+		return err
+	}
+
+	return coder.Decoder(r.body, v)
+}
+
+// Err 返回流结束的原因, 正常结束时返回nil
+func (r *StreamReader) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.err
+}
+
+// Close 停止接收, 流还没有结束时通知服务端取消处理
+func (r *StreamReader) Close() error {
+	select {
+	case <-r.finished:
+		return nil
+	default:
+	}
+
+	r.finish(nil)
+
+	return r.control(linker.Header{"control": {"cancel"}})
+}
+
+// control 向服务端发送流的控制消息
+func (r *StreamReader) control(header linker.Header) error {
+	p, err := r.client.newPacket(r.operator, r.sequence, header, nil)
+	if err != nil {
+		return err
+	}
+
+	p.Flags |= linker.FlagControl
+
+	return r.send(p)
+}
+
+// send 在流所在的连接上发送数据包
+func (r *StreamReader) send(p linker.Packet) error {
+	return r.client.write(outgoing{packet: p, lost: r.lost})
+}
+
+// send 在当前连接上发送数据包
+func (c *Client) send(p linker.Packet) error {
+	return c.write(outgoing{packet: p, lost: c.generation()})
+}
+
+// write 把数据包交给发送循环, 所属的连接已经断开或者客户端关闭时返回错误
+func (c *Client) write(o outgoing) error {
+	select {
+	case <-o.lost:
+		return errConnectionLost
+	default:
+	}
+
+	select {
+	case c.packet <- o:
+		return nil
+	case <-o.lost:
+		return errConnectionLost
+	case <-c.done:
+		return errConnectionClosed
+	}
+}
@@ -6,21 +6,10 @@ import (
 )
 
 func (c *Client) SyncSendWithTimeout(ctx context.Context, operator string, param interface{}, callback RequestStatusCallback) error {
-	ch := make(chan error, 1)
-	go func() {
-		ch <- c.SyncSend(operator, param, callback)
-	}()
-
-	for {
-		select {
-		case err := <-ch:
-			return err
-		case <-ctx.Done():
-			err := ctx.Err()
-			if err != nil {
-				err = fmt.Errorf("%s:%w", operator, err)
-			}
-			return err
-		}
+	err := c.syncSend(ctx, operator, param, withDeadline(ctx, c.requestHeader()), callback)
+	if err != nil && err == ctx.Err() {
+		err = fmt.Errorf("%s:%w", operator, err)
 	}
+
+	return err
 }
@@ -1,22 +1,37 @@
 package client
 
 import (
+	"crypto/tls"
 	"time"
 
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/client/export"
 	"github.com/wpajqz/linker/plugin"
+	"github.com/wpajqz/linker/resolver"
 )
 
 type (
 	options struct {
 		network                 string
 		udpPayload              int
+		maxHeaderSize           int
+		maxBodySize             int
+		maxFrameSize            int
 		dialTimeout             time.Duration
 		initialCap              int
 		maxCap                  int
 		contentType             string
+		operators               linker.OperatorTable
+		tlsConfig               *tls.Config
 		idleTimeout             time.Duration
 		onOpen, onClose         func()
 		onError                 func(error)
+		onStateChange           func(from, to int)
+		reconnect               *export.ReconnectPolicy
+		balancer                Balancer
+		resolver                resolver.Resolver
+		maxFailures             int
+		probeInterval           time.Duration
 		pluginForPacketSender   []plugin.PacketPlugin
 		pluginForPacketReceiver []plugin.PacketPlugin
 	}
@@ -24,6 +39,7 @@ type (
 	Option func(*options)
 )
 
+// Network 连接使用的网络, 支持tcp, udp和unix, 使用unix时地址为socket文件的路径
 func Network(n string) Option {
 	return Option(func(o *options) {
 		o.network = n
@@ -36,6 +52,27 @@ func UDPPayload(size int) Option {
 	}
 }
 
+// MaxHeaderSize 接收数据包header的最大长度, 默认linker.DefaultMaxHeaderSize
+func MaxHeaderSize(size int) Option {
+	return func(o *options) {
+		o.maxHeaderSize = size
+	}
+}
+
+// MaxBodySize 接收数据包body的最大长度, 默认linker.DefaultMaxBodySize
+func MaxBodySize(size int) Option {
+	return func(o *options) {
+		o.maxBodySize = size
+	}
+}
+
+// MaxFrameSize 接收数据包的最大总长度, 默认只限制header和body
+func MaxFrameSize(size int) Option {
+	return func(o *options) {
+		o.maxFrameSize = size
+	}
+}
+
 func DialTimeout(n time.Duration) Option {
 	return Option(func(o *options) {
 		o.dialTimeout = n
@@ -48,12 +85,57 @@ func ContentType(mime string) Option {
 	}
 }
 
+// Operators 设置显式的operator表, 需要和服务端Router.Operators使用的表一致
+func Operators(table linker.OperatorTable) Option {
+	return func(o *options) {
+		o.operators = table
+	}
+}
+
+// TLSConfig tcp连接使用TLS, 双向认证时在config.Certificates中设置客户端证书
+func TLSConfig(config *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = config
+	}
+}
+
+// LoadBalancer 多个地址之间的负载均衡策略, 默认RoundRobin
+func LoadBalancer(b Balancer) Option {
+	return func(o *options) {
+		o.balancer = b
+	}
+}
+
+// WithResolver 地址由r推送, 地址变化时建立新地址的连接池并关闭移除的地址.
+// NewClient的address作为第一次推送之前使用的地址, 可以为空
+func WithResolver(r resolver.Resolver) Option {
+	return func(o *options) {
+		o.resolver = r
+	}
+}
+
+// HealthCheck 地址连续失败maxFailures次以后摘除, 每隔probeInterval发送一次心跳, 服务端回复以后重新使用.
+// 默认连续失败3次, 每2s探测一次
+func HealthCheck(maxFailures int, probeInterval time.Duration) Option {
+	return func(o *options) {
+		if maxFailures > 0 {
+			o.maxFailures = maxFailures
+		}
+
+		if probeInterval > 0 {
+			o.probeInterval = probeInterval
+		}
+	}
+}
+
+// InitialCapacity 每个地址的连接池初始建立的连接数
 func InitialCapacity(n int) Option {
 	return Option(func(o *options) {
 		o.initialCap = n
 	})
 }
 
+// MaxCapacity 每个地址的连接池最多保留的连接数
 func MaxCapacity(n int) Option {
 	return Option(func(o *options) {
 		o.maxCap = n
@@ -84,6 +166,20 @@ func WithOnError(fn func(err error)) Option {
 	})
 }
 
+// WithOnStateChange 连接状态变化的回调, 状态为export包中的OPEN, RECONNECTING等常量
+func WithOnStateChange(fn func(from, to int)) Option {
+	return Option(func(o *options) {
+		o.onStateChange = fn
+	})
+}
+
+// Reconnect 连接断开以后按照policy自动重连, 并重新注册事件监听器
+func Reconnect(policy export.ReconnectPolicy) Option {
+	return Option(func(o *options) {
+		o.reconnect = &policy
+	})
+}
+
 func PluginForPacketSender(plugins ...plugin.PacketPlugin) Option {
 	return func(o *options) {
 		o.pluginForPacketSender = append(o.pluginForPacketSender, plugins...)
@@ -13,7 +13,8 @@ import (
 
 var interval int64 = 60
 
-func (c *Client) newExportPool() (pool.Pool, error) {
+// newExportPool 创建连接到address的连接池
+func (c *Client) newExportPool(address string, opts ...export.Option) (pool.Pool, error) {
 	// ping请求的回调，出错的时候调用
 	cb := RequestStatusCallback{
 		Error: func(code int, msg string) {
@@ -23,50 +24,40 @@ func (c *Client) newExportPool() (pool.Pool, error) {
 
 	// factory 创建连接的方法
 	factory := func() (interface{}, error) {
-		var (
-			exportClient *export.Client
-			err          error
-		)
-
-		// 出现错误的时候不退出，继续等待服务端恢复以后进行重连
-		address, err := c.getAddress()
-		if err != nil {
-			c.fillAddress(c.address)
-			if c.options.onError != nil {
-				c.options.onError(err)
-			}
-		}
-
-		if c.options.network == linker.NetworkTCP {
-			exportClient, err = export.NewClient(address, &ReadyStateCallback{Open: c.options.onOpen, Close: c.options.onClose, Error: func(err string) { c.options.onError(errors.New(err)) }})
-		} else {
-			exportClient, err = export.NewUDPClient(address, &ReadyStateCallback{Open: c.options.onOpen, Close: c.options.onClose, Error: func(err string) { c.options.onError(errors.New(err)) }})
+		readyState := &ReadyStateCallback{
+			Open:  c.options.onOpen,
+			Close: c.options.onClose,
+			Error: func(err string) {
+				if c.options.onError != nil {
+					c.options.onError(errors.New(err))
+				}
+			},
+			StateChange: c.options.onStateChange,
 		}
 
+		exportClient, err := c.dial(address, readyState, append([]export.Option{export.Reconnect(c.options.reconnect)}, opts...)...)
 		if err != nil {
 			return nil, fmt.Errorf("brpc error: %s\n", err.Error())
 		}
 
-		exportClient.SetUDPPayload(c.options.udpPayload)
-		exportClient.SetContentType(c.options.contentType)
-		exportClient.SetPluginForPacketSender(c.options.pluginForPacketSender...)
-		exportClient.SetPluginForPacketReceiver(c.options.pluginForPacketReceiver...)
-
+		// 定时发送心跳, 连接关闭以后停止
 		go func(ec *export.Client) {
 			ticker := time.NewTicker(time.Duration(interval) * time.Second)
+			defer ticker.Stop()
+
 			for {
 				select {
 				case <-ticker.C:
 					err := ec.Ping(nil, cb)
-					if err != nil {
+					if err != nil && err != export.ErrReconnecting {
 						return
 					}
+				case <-ec.Done():
+					return
 				}
 			}
 		}(exportClient)
 
-		c.availableAddress <- address
-
 		return exportClient, nil
 	}
 
@@ -90,3 +81,26 @@ func (c *Client) newExportPool() (pool.Pool, error) {
 
 	return pool.NewChannelPool(pc)
 }
+
+// dial 按照客户端的设置建立一个连接, 插件等设置在建立连接之前传入, 握手请求也会经过插件处理
+func (c *Client) dial(address string, readyState *ReadyStateCallback, opts ...export.Option) (*export.Client, error) {
+	opts = append([]export.Option{
+		export.UDPPayload(c.options.udpPayload),
+		export.FrameLimits(c.options.maxHeaderSize, c.options.maxBodySize, c.options.maxFrameSize),
+		export.ContentType(c.options.contentType),
+		export.Operators(c.options.operators),
+		export.PluginForPacketSender(c.options.pluginForPacketSender...),
+		export.PluginForPacketReceiver(c.options.pluginForPacketReceiver...),
+	}, opts...)
+
+	switch {
+	case c.options.network == linker.NetworkTCP && c.options.tlsConfig != nil:
+		return export.NewTLSClient(address, c.options.tlsConfig, readyState, opts...)
+	case c.options.network == linker.NetworkTCP:
+		return export.NewClient(address, readyState, opts...)
+	case c.options.network == linker.NetworkUnix:
+		return export.NewUnixClient(address, readyState, opts...)
+	default:
+		return export.NewUDPClient(address, readyState, opts...)
+	}
+}
@@ -0,0 +1,372 @@
+package linker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// 所有实例订阅的主题, 用于发现其它实例
+	clusterTopic = "linker:cluster"
+	// 实例定期广播自己的存在, 超过三个周期没有消息的实例被认为已经下线
+	clusterAnnounceInterval = 5 * time.Second
+	// 等待其它实例回复推送结果的时间, 推送不需要等待客户端处理, 很快就会回复
+	clusterSendTimeout = 2 * time.Second
+)
+
+// 实例之间传递的消息类型
+const (
+	clusterHello    = "hello"
+	clusterAnnounce = "announce"
+	clusterLeave    = "leave"
+	clusterCall     = "call"
+	clusterSend     = "send"
+	clusterReply    = "reply"
+)
+
+type (
+	// cluster 通过Broker发现同一个集群中的其它实例, 把本地找不到的节点的请求转发过去.
+	// 每个实例订阅clusterTopic和自己的主题, 转发的请求和回复都发送到实例自己的主题
+	cluster struct {
+		server   *Server
+		mutex    sync.Mutex
+		peers    map[string]time.Time
+		pending  map[uint64]chan clusterMessage
+		sequence uint64
+		done     chan struct{}
+	}
+
+	clusterMessage struct {
+		Kind     string `json:"kind"`
+		From     string `json:"from"`
+		ID       uint64 `json:"id,omitempty"`
+		Target   string `json:"target,omitempty"`
+		Operator string `json:"operator,omitempty"`
+		Body     []byte `json:"body,omitempty"`
+		// 转发的调用剩余的时间, 单位毫秒
+		Timeout int64 `json:"timeout,omitempty"`
+		// 回复: 推送成功的连接数量, 错误, 目标不在该实例上, 目标是该实例上的节点ID
+		Count    int    `json:"count,omitempty"`
+		Code     int    `json:"code,omitempty"`
+		Message  string `json:"message,omitempty"`
+		NotFound bool   `json:"not_found,omitempty"`
+		Node     bool   `json:"node,omitempty"`
+	}
+)
+
+func newCluster(s *Server) *cluster {
+	return &cluster{
+		server:  s,
+		peers:   make(map[string]time.Time),
+		pending: make(map[uint64]chan clusterMessage),
+		done:    make(chan struct{}),
+	}
+}
+
+// topic 实例自己的主题
+func (c *cluster) topic(instance string) string {
+	return clusterTopic + ":" + instance
+}
+
+// start 订阅集群的主题并通知其它实例, 其它实例收到以后回复自己的存在
+func (c *cluster) start() error {
+	b, id := c.server.options.broker, c.server.id
+
+	if err := b.Subscribe(id, clusterTopic, c.receive); err != nil {
+		return err
+	}
+
+	if err := b.Subscribe(id, c.topic(id), c.receive); err != nil {
+		return err
+	}
+
+	if err := c.publish(clusterTopic, clusterMessage{Kind: clusterHello}); err != nil {
+		return err
+	}
+
+	go c.announce()
+
+	return nil
+}
+
+// stop 通知其它实例自己已经下线
+func (c *cluster) stop() {
+	close(c.done)
+
+	b, id := c.server.options.broker, c.server.id
+	if err := c.publish(clusterTopic, clusterMessage{Kind: clusterLeave}); err != nil {
+		fmt.Printf("cluster leave error: %s\n", err.Error())
+	}
+
+	_ = b.UnSubscribe(id, clusterTopic)
+	_ = b.UnSubscribe(id, c.topic(id))
+}
+
+// announce 定期广播自己的存在, 同时移除长时间没有消息的实例
+func (c *cluster) announce() {
+	ticker := time.NewTicker(clusterAnnounceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := c.publish(clusterTopic, clusterMessage{Kind: clusterAnnounce}); err != nil {
+				fmt.Printf("cluster announce error: %s\n", err.Error())
+			}
+
+			c.mutex.Lock()
+			for peer, seen := range c.peers {
+				if now.Sub(seen) > 3*clusterAnnounceInterval {
+					delete(c.peers, peer)
+				}
+			}
+			c.mutex.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *cluster) publish(topic string, msg clusterMessage) error {
+	msg.From = c.server.id
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return c.server.options.broker.Publish(topic, data)
+}
+
+// receive 处理Broker上收到的消息, 转发的请求在单独的goroutine中处理, 不阻塞后续的消息
+func (c *cluster) receive(data []byte) {
+	var msg clusterMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		fmt.Printf("cluster message error: %s\n", err.Error())
+		return
+	}
+
+	if msg.From == c.server.id {
+		return
+	}
+
+	switch msg.Kind {
+	case clusterHello:
+		c.seen(msg.From)
+		if err := c.publish(c.topic(msg.From), clusterMessage{Kind: clusterAnnounce}); err != nil {
+			fmt.Printf("cluster announce error: %s\n", err.Error())
+		}
+	case clusterAnnounce:
+		c.seen(msg.From)
+	case clusterLeave:
+		c.mutex.Lock()
+		delete(c.peers, msg.From)
+		c.mutex.Unlock()
+	case clusterCall, clusterSend:
+		c.seen(msg.From)
+		go c.serve(msg)
+	case clusterReply:
+		c.mutex.Lock()
+		ch, ok := c.pending[msg.ID]
+		c.mutex.Unlock()
+
+		if ok {
+			ch <- msg
+		}
+	}
+}
+
+func (c *cluster) seen(peer string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.peers[peer] = time.Now()
+}
+
+// instances 已知的其它实例
+func (c *cluster) instances() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	peers := make([]string, 0, len(c.peers))
+	for peer := range c.peers {
+		peers = append(peers, peer)
+	}
+
+	sort.Strings(peers)
+
+	return peers
+}
+
+// owners 可能持有节点的实例, 配置了Presence时直接找到节点所在的实例
+func (c *cluster) owners(nodeID string) []string {
+	if p := c.server.options.presence; p != nil {
+		if entry, ok, err := p.Get(nodeID); err == nil && ok && entry.Instance != c.server.id {
+			return []string{entry.Instance}
+		}
+	}
+
+	return c.instances()
+}
+
+// serve 处理其它实例转发过来的请求, 并把结果回复给它
+func (c *cluster) serve(msg clusterMessage) {
+	reply := clusterMessage{Kind: clusterReply, ID: msg.ID}
+
+	switch msg.Kind {
+	case clusterCall:
+		conn := c.server.connections.Get(msg.Target)
+		if conn == nil {
+			reply.NotFound = true
+			break
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(msg.Timeout)*time.Millisecond)
+		body, err := conn.call(ctx, c.server.operator(msg.Operator), msg.Body)
+		cancel()
+
+		reply.Body = body
+		if err != nil {
+			reply.Message = err.Error()
+			if e, ok := err.(*Error); ok {
+				reply.Code, reply.Message = e.Code, e.Message
+			}
+		}
+	case clusterSend:
+		n, err := c.server.sendLocal(msg.Target, msg.Operator, msg.Body)
+		reply.Count = n
+		reply.Node = c.server.connections.Get(msg.Target) != nil
+		if err == ErrNodeNotFound {
+			reply.NotFound = true
+		} else if err != nil {
+			reply.Message = err.Error()
+		}
+	}
+
+	if err := c.publish(c.topic(msg.From), reply); err != nil {
+		fmt.Printf("cluster reply error: %s\n", err.Error())
+	}
+}
+
+// request 把请求发送到instances, 回复通过返回的channel交给调用方, 调用方结束以后调用release
+func (c *cluster) request(instances []string, msg clusterMessage) (<-chan clusterMessage, func(), error) {
+	ch := make(chan clusterMessage, len(instances))
+
+	c.mutex.Lock()
+	c.sequence++
+	msg.ID = c.sequence
+	c.pending[msg.ID] = ch
+	c.mutex.Unlock()
+
+	release := func() {
+		c.mutex.Lock()
+		delete(c.pending, msg.ID)
+		c.mutex.Unlock()
+	}
+
+	for _, instance := range instances {
+		if err := c.publish(c.topic(instance), msg); err != nil {
+			release()
+			return nil, nil, err
+		}
+	}
+
+	return ch, release, nil
+}
+
+// call 把调用转发给持有节点的实例
+func (c *cluster) call(ctx context.Context, nodeID, operator string, body []byte) ([]byte, error) {
+	instances := c.owners(nodeID)
+	if len(instances) == 0 {
+		return nil, ErrNodeNotFound
+	}
+
+	deadline, _ := ctx.Deadline()
+	ch, release, err := c.request(instances, clusterMessage{
+		Kind:     clusterCall,
+		Target:   nodeID,
+		Operator: operator,
+		Body:     body,
+		Timeout:  int64(time.Until(deadline) / time.Millisecond),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	for range instances {
+		select {
+		case reply := <-ch:
+			if reply.NotFound {
+				continue
+			}
+
+			if reply.Code != 0 {
+				return nil, &Error{Code: reply.Code, Message: reply.Message}
+			}
+
+			if reply.Message != "" {
+				return nil, errors.New(reply.Message)
+			}
+
+			return reply.Body, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, ErrNodeNotFound
+}
+
+// send 把推送转发给可能持有目标的实例, 返回它们推送成功的连接数量.
+// 节点ID只会在一个实例上, 收到推送成功的节点回复以后不再等待其它实例
+func (c *cluster) send(target, operator string, body []byte) (int, error) {
+	instances := c.owners(target)
+	if len(instances) == 0 {
+		return 0, nil
+	}
+
+	ch, release, err := c.request(instances, clusterMessage{Kind: clusterSend, Target: target, Operator: operator, Body: body})
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	timeout := time.NewTimer(clusterSendTimeout)
+	defer timeout.Stop()
+
+	var n int
+	for range instances {
+		select {
+		case reply := <-ch:
+			if reply.Node && reply.Count > 0 {
+				return reply.Count, nil
+			}
+
+			n += reply.Count
+			if reply.Message != "" && err == nil {
+				err = errors.New(reply.Message)
+			}
+		case <-timeout.C:
+			return n, context.DeadlineExceeded
+		case <-c.done:
+			return n, ErrServerClosed
+		}
+	}
+
+	return n, err
+}
+
+// Instances 集群中已经发现的其它实例, 没有开启集群模式时返回nil
+func (s *Server) Instances() []string {
+	if s.cluster == nil {
+		return nil
+	}
+
+	return s.cluster.instances()
+}
@@ -0,0 +1,127 @@
+package linker_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/broker/memory"
+	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker/client/export"
+)
+
+func TestServerCluster(t *testing.T) {
+	b := memory.NewBroker()
+
+	var servers []*linker.Server
+	var addresses []string
+	for i := 0; i < 3; i++ {
+		router := linker.NewRouter()
+		router.Route("/login", linker.HandlerFunc(func(ctx linker.Context) {
+			ctx.Connection().Tag("user:1")
+			ctx.Success(ctx.NodeID())
+		}))
+
+		s, address, _ := runServer(t, router, linker.Broker(b), linker.WithCluster())
+		defer s.Shutdown(context.Background())
+
+		servers = append(servers, s)
+		addresses = append(addresses, address)
+	}
+
+	for i := 0; i < 100 && len(servers[0].Instances()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := len(servers[0].Instances()); n != 2 {
+		t.Fatalf("unexpected instances: %d", n)
+	}
+
+	received := make(chan string, 4)
+	login := func(address string) string {
+		c := dial(t, address)
+		c.HandleCall("/status", export.CallHandlerFunc(func(header linker.Header, body []byte) (interface{}, error) {
+			return "ready", nil
+		}))
+
+		if err := c.AddMessageListener("notice", export.HandlerFunc(func(header, body []byte) {
+			received <- string(body)
+		})); err != nil {
+			t.Fatal(err)
+		}
+
+		id := make(chan string, 1)
+		if err := c.SyncSend("/login", nil, client.RequestStatusCallback{
+			Success: func(header, body []byte) {
+				v, _ := strconv.Unquote(string(body))
+				id <- v
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		return <-id
+	}
+
+	// 客户端连接在第二个和第三个实例上, 通过第一个实例访问
+	id := login(addresses[1])
+	_ = login(addresses[2])
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	body, err := servers[0].Call(ctx, id, "/status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != `"ready"` {
+		t.Errorf("unexpected reply: %s", body)
+	}
+
+	if _, err := servers[0].Call(ctx, "missing", "/status", nil); err != linker.ErrNodeNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	n, err := servers[0].SendTo("user:1", "notice", []byte("hello"))
+	if err != nil || n != 2 {
+		t.Fatalf("send to tag: %d, %v", n, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case v := <-received:
+			if v != "hello" {
+				t.Errorf("unexpected message: %s", v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no message")
+		}
+	}
+
+	// 节点ID只在一个实例上
+	n, err = servers[0].SendTo(id, "notice", []byte("node"))
+	if err != nil || n != 1 {
+		t.Fatalf("send to node: %d, %v", n, err)
+	}
+
+	select {
+	case v := <-received:
+		if v != "node" {
+			t.Errorf("unexpected message: %s", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+	}
+
+	_ = servers[2].Shutdown(context.Background())
+	for i := 0; i < 100 && len(servers[0].Instances()) > 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := len(servers[0].Instances()); n != 1 {
+		t.Errorf("unexpected instances after leave: %d", n)
+	}
+}
@@ -0,0 +1,290 @@
+package linker
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// streamConn 基于字节流的连接, tcp等传输层共用
+type streamConn struct {
+	net.Conn
+	mutex       sync.Mutex
+	timeout     time.Duration
+	limits      FrameLimits
+	credentials *Credentials
+	// 读取使用的协议版本, 只在读循环中访问
+	version uint8
+}
+
+func newStreamConn(conn net.Conn, options Options) *streamConn {
+	return &streamConn{Conn: conn, timeout: options.timeout, limits: options.frameLimits()}
+}
+
+func (c *streamConn) ReadPacket() (Packet, error) {
+	return ReadPacket(c.Conn, c.version, c.limits)
+}
+
+func (c *streamConn) setProtocol(version uint8) {
+	c.version = version
+}
+
+func (c *streamConn) WritePacket(p Packet) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.timeout != 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+			return err
+		}
+	}
+
+	_, err := c.Conn.Write(p.Bytes())
+
+	return err
+}
+
+// TLSState 连接的TLS状态, 不是TLS连接时返回nil
+func (c *streamConn) TLSState() *tls.ConnectionState {
+	tc, ok := c.Conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	state := tc.ConnectionState()
+
+	return &state
+}
+
+// PeerCredentials unix socket对端进程的身份, 其它连接返回nil
+func (c *streamConn) PeerCredentials() *Credentials {
+	return c.credentials
+}
+
+// serveConn 处理传输层交过来的连接, 服务正在关闭时直接关闭连接
+func (s *Server) serveConn(conn Conn) {
+	if !s.trackConn(conn, true) {
+		_ = conn.Close()
+		return
+	}
+
+	err := s.handleConnection(conn)
+	if err != nil && err != io.EOF && !s.shuttingDown() {
+		fmt.Printf("%s connection error: %s\n", conn.LocalAddr().Network(), err.Error())
+	}
+}
+
+// handleConnection 读取连接上的数据包, 每个请求在单独的goroutine中处理
+func (s *Server) handleConnection(conn Conn) error {
+	connCtx, cancel := newConnContext()
+	s.bindConnCancel(conn, cancel)
+
+	ctx := NewContextConn(connCtx, conn, 0, 0, nil, nil, s.options)
+	ctx.Set(nodeID, uuid.NewV4().String())
+
+	// 在连接建立的回调之前注册, 回调中就可以给连接设置标签
+	remote := newConnection(ctx.NodeID(), conn, s)
+	ctx.Set(connectionKey, remote)
+	s.connections.add(remote)
+	s.tracker.touch(remote)
+
+	if s.options.constructHandler != nil {
+		s.options.constructHandler.Handle(ctx)
+	}
+
+	var packets sync.WaitGroup
+	requests := newInflight()
+	defer func() {
+		// 客户端断开连接时通知正在处理的请求, 关闭服务时则等待请求正常完成,
+		// Shutdown的ctx到期以后由Shutdown调用cancel
+		if !s.shuttingDown() {
+			cancel()
+		}
+
+		requests.close()
+
+		packets.Wait()
+		cancel()
+
+		s.rooms.leaveAll(remote)
+		s.connections.remove(remote)
+		s.tracker.remove(remote)
+		remote.close()
+
+		if s.options.destructHandler != nil {
+			s.options.destructHandler.Handle(ctx)
+		}
+
+		if err := ctx.UnSubscribeAll(); err != nil {
+			fmt.Printf("unsubscribe error: %s\n", err.Error())
+		}
+
+		_ = conn.Close()
+		s.trackConn(conn, false)
+	}()
+
+	for {
+		if s.options.timeout != 0 {
+			err := conn.SetReadDeadline(time.Now().Add(s.options.timeout))
+			if err != nil {
+				return err
+			}
+		}
+
+		// Shutdown通过读超时中断读取, 重新设置deadline以后需要再次确认
+		if s.shuttingDown() {
+			return nil
+		}
+
+		p, err := conn.ReadPacket()
+		if err == ErrFrameTooLarge || err == ErrChecksum {
+			// 流已经无法继续解析, 回复错误以后关闭连接
+			code := StatusRequestEntityTooLarge
+			if err == ErrChecksum {
+				code = StatusBadRequest
+			}
+
+			if err := rejectPacket(conn, p, code, s.options); err != nil {
+				fmt.Printf("write response error: %s\n", err.Error())
+			}
+
+			return err
+		}
+
+		// 数据包已经完整读取, 回复错误以后继续处理下一个
+		if err == ErrUnsupportedFlags {
+			if err := rejectPacket(conn, p, StatusBadRequest, s.options); err != nil {
+				fmt.Printf("write response error: %s\n", err.Error())
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		rp, err := NewPacket(p.Operator, p.Sequence, p.Header, p.Body, s.options.pluginForPacketReceiver)
+		if err != nil {
+			return err
+		}
+
+		rp.Version, rp.Flags = p.Version, p.Flags
+
+		// 客户端对服务端主动调用的回复
+		if rp.Sequence < 0 {
+			remote.resolve(rp)
+			continue
+		}
+
+		key := requestKey{operator: rp.Operator, sequence: rp.Sequence}
+
+		// 已经打开的双向流上客户端发送的消息, 流已经结束的直接丢弃
+		if rp.Flags&(FlagStream|FlagOpenStream|FlagControl) == FlagStream {
+			if st := requests.stream(key); st != nil {
+				st.deliver(rp)
+			}
+
+			continue
+		}
+
+		// header的格式由协议版本决定, 设置版本以后再解析
+		rctx := NewContextConn(ctx.Context, conn, rp.Operator, rp.Sequence, nil, rp.Body, s.options)
+		rctx.setProtocol(rp.Version, rp.Flags)
+		rctx.setRequestHeader(rp.Header)
+		if rctx.headerErr != nil {
+			if err := rejectPacket(conn, rp, StatusBadRequest, s.options); err != nil {
+				fmt.Printf("write response error: %s\n", err.Error())
+			}
+
+			continue
+		}
+
+		rcancel := rctx.withRequestDeadline()
+		if rp.Flags&FlagControl != 0 {
+			s.handleControl(rctx, rp, requests)
+			rcancel()
+			continue
+		}
+
+		// 握手在读循环中处理, 下一个数据包按照协商的版本读取
+		if rp.Operator == OperatorHandshake {
+			s.handleHandshake(rctx, remote)
+			rcancel()
+			continue
+		}
+
+		requests.add(key, rcancel)
+		rctx.onStream = func(st *serverStream) {
+			st.violation = rcancel
+			requests.addStream(key, st)
+		}
+
+		if rp.Flags&FlagOpenStream != 0 {
+			st := rctx.openStream()
+			if rp.Flags&FlagEndOfStream != 0 {
+				st.closeSend()
+			}
+		}
+
+		packets.Add(1)
+		go func(ctx Context, cancel context.CancelFunc, key requestKey, rp Packet) {
+			defer packets.Done()
+			defer cancel()
+			defer requests.remove(key)
+
+			switch rp.Operator {
+			case OperatorHeartbeat:
+				s.handleHeartbeat(ctx)
+				s.tracker.touch(remote)
+			default:
+				s.handlePacket(ctx, rp)
+			}
+		}(rctx, rcancel, key, rp)
+	}
+}
+
+// rejectPacket 回复不合法的数据包, 不经过路由和中间件
+func rejectPacket(conn Conn, p Packet, code int, options Options) error {
+	ctx := NewContextConn(context.Background(), conn, p.Operator, p.Sequence, nil, nil, options)
+	ctx.setProtocol(p.Version, 0)
+	ctx.Error(code, StatusText(code))
+
+	return ctx.writeResponse()
+}
+
+// handlePacket 把请求交给路由处理, 处理结束以后统一写回复
+func (s *Server) handlePacket(ctx Context, rp Packet) {
+	defer s.writeResponse(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			var errMsg string
+
+			switch v := r.(type) {
+			case string:
+				errMsg = v
+			case error:
+				errMsg = v.Error()
+			default:
+				errMsg = StatusText(StatusInternalServerError)
+			}
+
+			ctx.Set(errorTag, errMsg)
+
+			if s.options.errorHandler != nil {
+				s.options.errorHandler.Handle(ctx)
+			}
+
+			ctx.Error(StatusInternalServerError, errMsg)
+		}
+	}()
+
+	s.router.lookup(ctx, rp.Operator).Handle(ctx)
+}
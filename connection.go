@@ -0,0 +1,319 @@
+package linker
+
+import (
+	"hash/crc32"
+	"sort"
+	"sync"
+)
+
+type (
+	// Connection 服务端上一个活跃的连接, 可以在处理器以外按照节点ID或者标签找到它并推送消息
+	Connection struct {
+		id       string
+		conn     Conn
+		options  Options
+		registry *Connections
+		rooms    *Rooms
+		tracker  *tracker
+		tags     map[string]struct{}
+		// 主动推送使用的协议版本, 握手时在writeMutex的保护下切换
+		writeMutex sync.RWMutex
+		version    uint8
+		// 服务端主动发起的调用
+		sequence int64
+		mutex    sync.Mutex
+		closed   bool
+		calls    map[int64]chan Packet
+	}
+
+	// Connections 所有传输层的活跃连接, 按照节点ID和用户设置的标签索引
+	Connections struct {
+		mutex sync.RWMutex
+		nodes map[string]*Connection
+		tags  map[string]map[string]*Connection
+	}
+)
+
+func newConnections() *Connections {
+	return &Connections{nodes: make(map[string]*Connection), tags: make(map[string]map[string]*Connection)}
+}
+
+// Get 按照节点ID查找连接, 不存在时返回nil
+func (r *Connections) Get(nodeID string) *Connection {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.nodes[nodeID]
+}
+
+// Tagged 返回设置了tag的所有连接
+func (r *Connections) Tagged(tag string) []*Connection {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	conns := make([]*Connection, 0, len(r.tags[tag]))
+	for _, c := range r.tags[tag] {
+		conns = append(conns, c)
+	}
+
+	return conns
+}
+
+// Lookup 按照节点ID或者标签查找连接, 节点ID优先
+func (r *Connections) Lookup(target string) []*Connection {
+	if c := r.Get(target); c != nil {
+		return []*Connection{c}
+	}
+
+	return r.Tagged(target)
+}
+
+// All 返回所有连接
+func (r *Connections) All() []*Connection {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	conns := make([]*Connection, 0, len(r.nodes))
+	for _, c := range r.nodes {
+		conns = append(conns, c)
+	}
+
+	return conns
+}
+
+// Len 活跃连接的数量
+func (r *Connections) Len() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return len(r.nodes)
+}
+
+// add 连接开始处理时加入索引
+func (r *Connections) add(c *Connection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.nodes[c.id] = c
+}
+
+// remove 连接关闭时从节点和标签的索引中移除
+func (r *Connections) remove(c *Connection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.nodes, c.id)
+	for tag := range c.tags {
+		r.untag(c, tag)
+	}
+}
+
+// tag 给连接设置标签, 已经关闭的连接不再加入索引
+func (r *Connections) tag(c *Connection, tags []string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.nodes[c.id]; !ok {
+		return false
+	}
+
+	for _, tag := range tags {
+		c.tags[tag] = struct{}{}
+
+		conns, ok := r.tags[tag]
+		if !ok {
+			conns = make(map[string]*Connection)
+			r.tags[tag] = conns
+		}
+
+		conns[c.id] = c
+	}
+
+	return true
+}
+
+func (r *Connections) untagAll(c *Connection, tags []string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.nodes[c.id]; !ok {
+		return false
+	}
+
+	for _, tag := range tags {
+		r.untag(c, tag)
+	}
+
+	return true
+}
+
+func (r *Connections) untag(c *Connection, tag string) {
+	delete(c.tags, tag)
+
+	if conns, ok := r.tags[tag]; ok {
+		delete(conns, c.id)
+		if len(conns) == 0 {
+			delete(r.tags, tag)
+		}
+	}
+}
+
+func newConnection(id string, conn Conn, s *Server) *Connection {
+	return &Connection{
+		id:       id,
+		conn:     conn,
+		options:  s.options,
+		registry: s.connections,
+		rooms:    s.rooms,
+		tracker:  s.tracker,
+		tags:     make(map[string]struct{}),
+		version:  ProtocolV1,
+		calls:    make(map[int64]chan Packet),
+	}
+}
+
+// NodeID 连接的节点ID
+func (c *Connection) NodeID() string {
+	return c.id
+}
+
+// Network 连接使用的网络, 比如tcp, udp, unix, websocket连接返回tcp
+func (c *Connection) Network() string {
+	return c.conn.LocalAddr().Network()
+}
+
+func (c *Connection) RemoteAddr() string {
+	return c.conn.RemoteAddr().String()
+}
+
+// Tag 给连接设置标签, 比如"user:42", 之后可以通过Connections.Tagged或者Server.SendTo找到它
+func (c *Connection) Tag(tags ...string) {
+	if c.registry.tag(c, tags) {
+		c.tracker.touch(c)
+	}
+}
+
+// Untag 移除连接的标签
+func (c *Connection) Untag(tags ...string) {
+	if c.registry.untagAll(c, tags) {
+		c.tracker.touch(c)
+	}
+}
+
+// Tags 连接的所有标签
+func (c *Connection) Tags() []string {
+	r := c.registry
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	tags := make([]string, 0, len(c.tags))
+	for tag := range c.tags {
+		tags = append(tags, tag)
+	}
+
+	sort.Strings(tags)
+
+	return tags
+}
+
+// Write 向客户端推送消息, 客户端通过AddMessageListener注册的处理器接收
+func (c *Connection) Write(operator string, body []byte) (int, error) {
+	p, err := c.writePacket(crc32.ChecksumIEEE([]byte(operator)), 0, body)
+	if err != nil {
+		return 0, err
+	}
+
+	return p.size(), nil
+}
+
+// writePacket 按照连接当前的协议版本生成数据包并写到连接, 握手切换版本时等待切换完成
+func (c *Connection) writePacket(operator uint32, sequence int64, body []byte) (Packet, error) {
+	c.writeMutex.RLock()
+	defer c.writeMutex.RUnlock()
+
+	header := Header{}.EncodeLegacy()
+	if c.version >= ProtocolV2 {
+		header = Header{}.Encode()
+	}
+
+	p, err := NewPacket(operator, sequence, header, body, c.options.pluginForPacketSender)
+	if err != nil {
+		return p, err
+	}
+
+	p.Version = c.version
+
+	return p, c.conn.WritePacket(p)
+}
+
+// upgrade 握手时切换协议版本, reply写握手的回复. 切换期间暂停主动推送,
+// 回复之前的推送使用旧的版本, 之后的使用新的版本, 和客户端切换读取版本的时机一致
+func (c *Connection) upgrade(version uint8, reply func()) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.version = version
+	reply()
+}
+
+// SendTo 向节点ID或者标签对应的所有连接推送消息, 返回成功推送的连接数量.
+// 开启集群模式时同时推送其它实例上的连接.
+// 没有找到连接时返回ErrNodeNotFound, 部分连接推送失败时返回第一个错误
+func (s *Server) SendTo(target, operator string, body []byte) (int, error) {
+	n, err := s.sendLocal(target, operator, body)
+	if s.cluster == nil {
+		return n, err
+	}
+
+	// 节点ID在本地找到时不需要转发
+	if s.connections.Get(target) != nil {
+		return n, err
+	}
+
+	if err == ErrNodeNotFound {
+		err = nil
+	}
+
+	m, e := s.cluster.send(target, operator, body)
+	if e != nil && err == nil {
+		err = e
+	}
+
+	if n+m == 0 && err == nil {
+		err = ErrNodeNotFound
+	}
+
+	return n + m, err
+}
+
+// sendLocal 向本地的连接推送消息
+func (s *Server) sendLocal(target, operator string, body []byte) (int, error) {
+	conns := s.connections.Lookup(target)
+	if len(conns) == 0 {
+		return 0, ErrNodeNotFound
+	}
+
+	var (
+		n   int
+		err error
+	)
+
+	for _, c := range conns {
+		if _, e := c.Write(operator, body); e != nil {
+			if err == nil {
+				err = e
+			}
+
+			continue
+		}
+
+		n++
+	}
+
+	return n, err
+}
+
+// Connections 服务端所有的活跃连接
+func (s *Server) Connections() *Connections {
+	return s.connections
+}
@@ -0,0 +1,97 @@
+package linker_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker/client/export"
+)
+
+func TestServerSendTo(t *testing.T) {
+	router := linker.NewRouter()
+	router.Route("/login", linker.HandlerFunc(func(ctx linker.Context) {
+		var user string
+		if err := ctx.ParseParam(&user); err != nil {
+			ctx.Error(linker.StatusBadRequest, err.Error())
+			return
+		}
+
+		ctx.Connection().Tag("user:" + user)
+		ctx.Success(ctx.NodeID())
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	received := make(chan string, 4)
+	login := func(user string) (*export.Client, string) {
+		c := dial(t, address)
+
+		if err := c.AddMessageListener("notice", export.HandlerFunc(func(header, body []byte) {
+			received <- user + ":" + string(body)
+		})); err != nil {
+			t.Fatal(err)
+		}
+
+		id := make(chan string, 1)
+		if err := c.SyncSend("/login", user, client.RequestStatusCallback{
+			Success: func(header, body []byte) {
+				v, _ := strconv.Unquote(string(body))
+				id <- v
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		return c, <-id
+	}
+
+	_, _ = login("1")
+	c2, id2 := login("2")
+	_, _ = login("1")
+
+	if n := s.Connections().Len(); n != 3 {
+		t.Fatalf("unexpected connections: %d", n)
+	}
+
+	if n, err := s.SendTo("user:1", "notice", []byte("hello")); err != nil || n != 2 {
+		t.Fatalf("send to tag: %d, %v", n, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case v := <-received:
+			if v != "1:hello" {
+				t.Errorf("unexpected message: %s", v)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no message")
+		}
+	}
+
+	if n, err := s.SendTo(id2, "notice", []byte("direct")); err != nil || n != 1 {
+		t.Fatalf("send to node: %d, %v", n, err)
+	}
+
+	select {
+	case v := <-received:
+		if v != "2:direct" {
+			t.Errorf("unexpected message: %s", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+	}
+
+	_ = c2.Close()
+	for i := 0; i < 100 && len(s.Connections().Tagged("user:2")) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := s.SendTo("user:2", "notice", nil); err != linker.ErrNodeNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -1,16 +1,25 @@
 package linker
 
 import (
-	"bytes"
 	"context"
-	"strings"
+	"crypto/tls"
+	"crypto/x509"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/wpajqz/linker/codec"
 )
 
+// 客户端通过该请求属性传递请求的超时时间, 单位毫秒
+const timeoutProperty = "timeout"
+
 type (
 	Context interface {
+		// 请求级别的context, 连接断开或者超过客户端传递的截止时间以后被取消
+		context.Context
+		// 连接级别的context, 连接断开以后被取消
+		ConnContext() context.Context
 		Set(key string, value interface{})
 		Get(key string) interface{}
 		MustGet(key string) interface{}
@@ -27,13 +36,35 @@ type (
 		Write(operator string, body []byte) (int, error)
 		Success(body interface{})
 		Error(code int, message string)
+		StatusCode() int
+		ResponseBody() []byte
+		OnResponse(fn func(Context))
+		// 开始流式回复, 之后Success和Error只影响最后一个数据包的状态, 需要客户端使用v2协议
+		Stream() (Stream, error)
 		Publish(topic string, message interface{}) error
+		// 请求和回复的header, Get/SetRequestProperty和Get/SetResponseProperty是它们的快捷方式
+		RequestHeader() Header
+		ResponseHeader() Header
 		SetRequestProperty(key, value string)
 		GetRequestProperty(key string) string
 		SetResponseProperty(key, value string)
 		GetResponseProperty(key string) string
 		LocalAddr() string
 		RemoteAddr() string
+		// 连接的节点ID, 服务端通过它主动调用客户端
+		NodeID() string
+		// 请求所属的连接, 可以给连接设置标签
+		Connection() *Connection
+		// 连接的TLS状态, 不是TLS连接时返回nil
+		TLS() *tls.ConnectionState
+		// 经过校验的客户端证书, 用于按照客户端证书鉴权
+		PeerCertificate() *x509.Certificate
+		// unix socket对端进程的身份
+		PeerCredentials() *Credentials
+		// 请求使用的协议版本, v1客户端返回ProtocolV1
+		Protocol() uint8
+		// 请求数据包的flags, 只有v2数据包有
+		Flags() uint8
 		InternalError() string
 		RawBody() []byte
 		Subscribe(topic string, process func([]byte)) error
@@ -43,17 +74,83 @@ type (
 	}
 
 	common struct {
-		options           Options
-		operateType       uint32
-		sequence          int64
-		body              []byte
-		Context           context.Context
-		Request, Response struct {
-			Header, Body []byte
+		options                       Options
+		version, flags                uint8
+		operateType                   uint32
+		sequence                      int64
+		body                          []byte
+		headerErr                     error
+		requestHeader, responseHeader Header
+		connContext                   context.Context
+		Context                       context.Context
+		reply                         struct {
+			sync.Mutex
+			done, written bool
+			streaming     bool
+			code          int
+			body          []byte
+			hooks         []func(Context)
 		}
 	}
+
+	// responder 由各个传输层的Context实现, 中间件链执行结束以后由Server统一写回复
+	responder interface {
+		replied() bool
+		writeResponse() error
+		responseHooks() []func(Context)
+	}
 )
 
+// newConnContext 创建连接级别的context, 连接处理结束时调用cancel
+func newConnContext() (context.Context, context.CancelFunc) {
+	return context.WithCancel(context.Background())
+}
+
+// withRequestDeadline 为请求创建可以取消的context, 客户端传递了timeout属性时同时设置截止时间
+func (dc *common) withRequestDeadline() context.CancelFunc {
+	var cancel context.CancelFunc
+	if v := dc.GetRequestProperty(timeoutProperty); v != "" {
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil && ms > 0 {
+			dc.Context, cancel = context.WithTimeout(dc.Context, time.Duration(ms)*time.Millisecond)
+
+			return cancel
+		}
+	}
+
+	dc.Context, cancel = context.WithCancel(dc.Context)
+
+	return cancel
+}
+
+// Deadline returns the time when work done on behalf of this request should be canceled.
+func (dc *common) Deadline() (deadline time.Time, ok bool) {
+	return dc.Context.Deadline()
+}
+
+// Done returns a channel that's closed when the connection is closed or the request times out.
+func (dc *common) Done() <-chan struct{} {
+	return dc.Context.Done()
+}
+
+// Err returns a non-nil error value after Done is closed.
+func (dc *common) Err() error {
+	return dc.Context.Err()
+}
+
+// Value returns the value associated with this context for key.
+func (dc *common) Value(key interface{}) interface{} {
+	return dc.Context.Value(key)
+}
+
+// ConnContext returns the context of the connection which the request belongs to.
+func (dc *common) ConnContext() context.Context {
+	if dc.connContext != nil {
+		return dc.connContext
+	}
+
+	return dc.Context
+}
+
 // Set is used to store a new key/value pair exclusively for this context.
 func (dc *common) Set(key string, value interface{}) {
 	dc.Context = context.WithValue(dc.Context, key, value)
@@ -187,6 +284,18 @@ func (dc *common) Publish(topic string, message interface{}) error {
 	return dc.options.broker.Publish(topic, data)
 }
 
+// NodeID returns the ID of the connection which the request belongs to.
+func (dc *common) NodeID() string {
+	return dc.GetString(nodeID)
+}
+
+// Connection returns the connection which the request belongs to.
+func (dc *common) Connection() *Connection {
+	c, _ := dc.Get(connectionKey).(*Connection)
+
+	return c
+}
+
 func (dc *common) Subscribe(topic string, process func([]byte)) error {
 	return dc.options.broker.Subscribe(dc.GetString(nodeID), topic, process)
 }
@@ -207,46 +316,180 @@ func (dc *common) UnSubscribeAll() error {
 	return nil
 }
 
-func (dc *common) SetRequestProperty(key, value string) {
-	v := dc.GetRequestProperty(key)
-	old := []byte(key + "=" + v + ";")
-	new := []byte("")
+// setRequestHeader 按照请求的协议版本解析header, 需要在setProtocol之后调用
+func (dc *common) setRequestHeader(data []byte) {
+	dc.requestHeader, dc.headerErr = DecodeHeader(data, dc.version)
+	if dc.headerErr != nil {
+		dc.requestHeader = make(Header)
+	}
 
-	dc.Request.Header = bytes.ReplaceAll(dc.Request.Header, old, new)
-	dc.Request.Header = append(dc.Request.Header, []byte(key+"="+value+";")...)
+	dc.responseHeader = make(Header)
 }
 
-func (dc *common) GetRequestProperty(key string) string {
-	values := strings.Split(string(dc.Request.Header), ";")
-	for _, value := range values {
-		kv := strings.Split(value, "=")
-		if kv[0] == key {
-			return kv[1]
-		}
+// encodeHeader 按照请求的协议版本编码header, v1请求使用旧格式
+func (dc *common) encodeHeader(h Header) []byte {
+	if dc.version < ProtocolV2 {
+		return h.EncodeLegacy()
 	}
 
-	return ""
+	return h.Encode()
+}
+
+// RequestHeader 返回请求的header
+func (dc *common) RequestHeader() Header {
+	return dc.requestHeader
+}
+
+// ResponseHeader 返回回复的header, 不能和Success, Error以及SetResponseProperty并发修改
+func (dc *common) ResponseHeader() Header {
+	return dc.responseHeader
+}
+
+func (dc *common) SetRequestProperty(key, value string) {
+	dc.requestHeader.Set(key, value)
+}
+
+func (dc *common) GetRequestProperty(key string) string {
+	return dc.requestHeader.Get(key)
 }
 
 func (dc *common) SetResponseProperty(key, value string) {
-	v := dc.GetResponseProperty(key)
-	old := []byte(key + "=" + v + ";")
-	new := []byte("")
+	dc.reply.Lock()
+	defer dc.reply.Unlock()
 
-	dc.Response.Header = bytes.ReplaceAll(dc.Response.Header, old, new)
-	dc.Response.Header = append(dc.Response.Header, []byte(key+"="+value+";")...)
+	dc.responseHeader.Set(key, value)
 }
 
 func (dc *common) GetResponseProperty(key string) string {
-	values := strings.Split(string(dc.Response.Header), ";")
-	for _, value := range values {
-		kv := strings.Split(value, "=")
-		if kv[0] == key {
-			return kv[1]
-		}
+	dc.reply.Lock()
+	defer dc.reply.Unlock()
+
+	return dc.responseHeader.Get(key)
+}
+
+// Success 记录请求成功的回复, 由Server在中间件链执行结束以后写到连接, 只有第一次调用Success或Error有效
+func (dc *common) Success(body interface{}) {
+	r, err := codec.NewCoder(dc.options.contentType)
+	if err != nil {
+		dc.Error(StatusInternalServerError, err.Error())
+		return
+	}
+
+	data, err := r.Encoder(body)
+	if err != nil {
+		dc.Error(StatusInternalServerError, err.Error())
+		return
+	}
+
+	dc.reply.Lock()
+	defer dc.reply.Unlock()
+
+	if dc.reply.done {
+		return
+	}
+
+	dc.reply.done = true
+	dc.reply.code = StatusOK
+	dc.reply.body = data
+}
+
+// Error 记录请求失败的回复, 由Server在中间件链执行结束以后写到连接, 只有第一次调用Success或Error有效
+func (dc *common) Error(code int, message string) {
+	dc.reply.Lock()
+	defer dc.reply.Unlock()
+
+	if dc.reply.done {
+		return
 	}
 
-	return ""
+	dc.reply.done = true
+	dc.reply.code = code
+	dc.responseHeader.Set("code", strconv.Itoa(code))
+	dc.responseHeader.Set("message", message)
+	dc.reply.body = nil
+}
+
+// StatusCode 返回记录的回复状态码, 还没有调用Success或Error时返回0
+func (dc *common) StatusCode() int {
+	dc.reply.Lock()
+	defer dc.reply.Unlock()
+
+	return dc.reply.code
+}
+
+// ResponseBody 返回记录的回复内容
+func (dc *common) ResponseBody() []byte {
+	dc.reply.Lock()
+	defer dc.reply.Unlock()
+
+	return dc.reply.body
+}
+
+// OnResponse 注册回复发送到客户端以后执行的回调, 按照注册的顺序执行
+func (dc *common) OnResponse(fn func(Context)) {
+	dc.reply.Lock()
+	defer dc.reply.Unlock()
+
+	dc.reply.hooks = append(dc.reply.hooks, fn)
+}
+
+func (dc *common) responseHooks() []func(Context) {
+	dc.reply.Lock()
+	defer dc.reply.Unlock()
+
+	return dc.reply.hooks
+}
+
+// replied 是否已经调用过Success或Error
+func (dc *common) replied() bool {
+	dc.reply.Lock()
+	defer dc.reply.Unlock()
+
+	return dc.reply.done
+}
+
+// responsePacket 生成回复的数据包, 已经生成过的返回false, 防止重复回复.
+// 回复和请求使用相同的协议版本, 请求要求校验时回复也带上校验值, 单向请求不回复
+func (dc *common) responsePacket() (Packet, bool, error) {
+	dc.reply.Lock()
+	defer dc.reply.Unlock()
+
+	if dc.reply.written || dc.flags&FlagOneWay != 0 {
+		return Packet{}, false, nil
+	}
+
+	dc.reply.written = true
+
+	// 流式回复的最后一个数据包只携带状态
+	body := dc.reply.body
+	if dc.reply.streaming {
+		body = nil
+	}
+
+	p, err := NewPacket(dc.operateType, dc.sequence, dc.encodeHeader(dc.responseHeader), body, dc.options.pluginForPacketSender)
+	p.Version, p.Flags = dc.version, dc.flags&FlagChecksum
+	if dc.reply.streaming {
+		p.Flags |= FlagStream | FlagEndOfStream
+	}
+
+	return p, true, err
+}
+
+// setProtocol 记录请求数据包的协议版本和flags
+func (dc *common) setProtocol(version, flags uint8) {
+	dc.version, dc.flags = version, flags
+}
+
+func (dc *common) Protocol() uint8 {
+	if dc.version < ProtocolV2 {
+		return ProtocolV1
+	}
+
+	return dc.version
+}
+
+func (dc *common) Flags() uint8 {
+	return dc.flags
 }
 
 func (dc *common) InternalError() string {
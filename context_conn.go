@@ -0,0 +1,110 @@
+package linker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"hash/crc32"
+	"sync"
+)
+
+var _ Context = new(ContextConn)
+
+// ContextConn 所有传输层共用的请求Context
+type ContextConn struct {
+	common
+	Conn Conn
+	// 保证流式回复的数据包在结束标记之前发送
+	streamMutex sync.Mutex
+	stream      *serverStream
+	// 开始流式回复时由Server登记流
+	onStream func(*serverStream)
+}
+
+func NewContextConn(ctx context.Context, conn Conn, OperateType uint32, Sequence int64, Header, Body []byte, options Options) *ContextConn {
+	c := &ContextConn{
+		common: common{
+			options:     options,
+			operateType: OperateType,
+			sequence:    Sequence,
+			connContext: ctx,
+			Context:     ctx,
+			body:        Body,
+		},
+		Conn: conn,
+	}
+
+	c.setRequestHeader(Header)
+
+	return c
+}
+
+// 把记录的回复写到连接
+func (c *ContextConn) writeResponse() error {
+	c.streamMutex.Lock()
+	defer c.streamMutex.Unlock()
+
+	p, ok, err := c.responsePacket()
+	if !ok || err != nil {
+		return err
+	}
+
+	return c.Conn.WritePacket(p)
+}
+
+// 向客户端发送数据
+func (c *ContextConn) Write(operator string, body []byte) (int, error) {
+	c.reply.Lock()
+	header := c.encodeHeader(c.responseHeader)
+	c.reply.Unlock()
+
+	p, err := NewPacket(crc32.ChecksumIEEE([]byte(operator)), 0, header, body, c.options.pluginForPacketSender)
+	if err != nil {
+		return 0, err
+	}
+
+	// 使用和请求相同的协议版本
+	p.Version, p.Flags = c.version, c.flags&FlagChecksum
+
+	if err := c.Conn.WritePacket(p); err != nil {
+		return 0, err
+	}
+
+	return p.size(), nil
+}
+
+func (c *ContextConn) LocalAddr() string {
+	return c.Conn.LocalAddr().String()
+}
+
+func (c *ContextConn) RemoteAddr() string {
+	return c.Conn.RemoteAddr().String()
+}
+
+// TLS 返回连接的TLS状态, 不是TLS连接时返回nil
+func (c *ContextConn) TLS() *tls.ConnectionState {
+	if ts, ok := c.Conn.(tlsStater); ok {
+		return ts.TLSState()
+	}
+
+	return nil
+}
+
+// PeerCertificate 返回经过校验的客户端证书, 客户端没有提供证书或者证书没有经过校验时返回nil
+func (c *ContextConn) PeerCertificate() *x509.Certificate {
+	state := c.TLS()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return state.VerifiedChains[0][0]
+}
+
+// PeerCredentials 返回unix socket对端进程的身份, 其它连接或者系统不支持时返回nil
+func (c *ContextConn) PeerCredentials() *Credentials {
+	if cr, ok := c.Conn.(credentialer); ok {
+		return cr.PeerCredentials()
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package linker_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+)
+
+func TestContextCancel(t *testing.T) {
+	started := make(chan struct{}, 1)
+	errs := make(chan [2]error, 1)
+
+	router := linker.NewRouter()
+	router.Route("/wait", linker.HandlerFunc(func(ctx linker.Context) {
+		started <- struct{}{}
+		<-ctx.Done()
+		errs <- [2]error{ctx.Err(), ctx.ConnContext().Err()}
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	waitStarted := func() {
+		t.Helper()
+
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("handler was not called")
+		}
+	}
+
+	canceled := func(want, wantConn error) {
+		t.Helper()
+
+		select {
+		case err := <-errs:
+			if err[0] != want || err[1] != wantConn {
+				t.Errorf("unexpected handler errors: %v, want %v and %v", err, want, wantConn)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("handler context was not canceled")
+		}
+	}
+
+	conn, err := net.Dial(linker.NetworkTCP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 客户端传递的截止时间只取消这个请求
+	writeRequest(t, conn, "/wait", 1, []byte("timeout=100;"), nil)
+	waitStarted()
+	canceled(context.DeadlineExceeded, nil)
+
+	// 客户端断开连接时取消连接上所有的请求
+	writeRequest(t, conn, "/wait", 2, nil, nil)
+	waitStarted()
+	_ = conn.Close()
+	canceled(context.Canceled, context.Canceled)
+}
+
+func TestContextSingleReply(t *testing.T) {
+	late := make(chan struct{})
+	lateDone := make(chan struct{})
+
+	router := linker.NewRouter()
+	router.Route("/twice", linker.HandlerFunc(func(ctx linker.Context) {
+		ctx.Success("first")
+		ctx.Error(linker.StatusBadRequest, "second")
+		ctx.Success("third")
+	}))
+	router.Route("/late", linker.HandlerFunc(func(ctx linker.Context) {
+		// 处理器返回以后才调用Success, 默认的回复已经发送
+		go func() {
+			defer close(lateDone)
+
+			<-late
+			ctx.Success("late")
+		}()
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	conn, err := net.Dial(linker.NetworkTCP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+	replies := make(map[int64][]linker.Packet)
+	read := func(sequence int64) {
+		t.Helper()
+
+		for len(replies[sequence]) == 0 {
+			p := readReply(t, conn)
+			replies[p.Sequence] = append(replies[p.Sequence], p)
+		}
+	}
+
+	writeRequest(t, conn, "/twice", 1, nil, nil)
+	writeRequest(t, conn, "/late", 2, nil, nil)
+	read(1)
+	read(2)
+
+	close(late)
+	<-lateDone
+
+	// 心跳的回复之前收到的数据包都已经读取
+	heartbeat, _ := linker.NewPacket(linker.OperatorHeartbeat, 3, nil, nil, nil)
+	if _, err := conn.Write(heartbeat.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	read(3)
+
+	for sequence, want := range map[int64]string{1: `"first"`, 2: "null"} {
+		if n := len(replies[sequence]); n != 1 {
+			t.Errorf("request %d got %d replies", sequence, n)
+			continue
+		}
+
+		if p := replies[sequence][0]; string(p.Body) != want || strings.Contains(string(p.Header), "code=") {
+			t.Errorf("request %d: unexpected reply %s %s", sequence, p.Body, p.Header)
+		}
+	}
+}
+
+func TestContextCancelOnForcedShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	errs := make(chan error, 1)
+	closed := make(chan struct{}, 1)
+
+	router := linker.NewRouter()
+	router.Route("/wait", linker.HandlerFunc(func(ctx linker.Context) {
+		started <- struct{}{}
+		<-ctx.Done()
+		errs <- ctx.Err()
+	}))
+
+	s, address, _ := runServer(t, router, linker.WithOnClose(linker.HandlerFunc(func(ctx linker.Context) {
+		closed <- struct{}{}
+	})))
+
+	conn, err := net.Dial(linker.NetworkTCP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	writeRequest(t, conn, "/wait", 1, nil, nil)
+	<-started
+
+	// 关闭服务时等待请求完成, ctx到期以后才取消请求
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Errorf("unexpected handler error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not canceled")
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("close handler was not called")
+	}
+}
@@ -49,11 +49,13 @@ func main() {
 				err := ctx.Publish(topic, map[string]interface{}{"subscribe": true})
 				if err != nil {
 					ctx.Error(linker.StatusInternalServerError, err.Error())
+					return
 				}
 
 				var param map[string]interface{}
 				if err := ctx.ParseParam(&param); err != nil {
 					ctx.Error(linker.StatusInternalServerError, err.Error())
+					return
 				}
 
 				ctx.Success(param)
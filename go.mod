@@ -11,6 +11,7 @@ require (
 	github.com/silenceper/pool v0.0.0-20191105065223-1f4530b6ba17
 	github.com/ugorji/go v1.1.7 // indirect
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
+	gopkg.in/yaml.v2 v2.2.2
 )
 
 go 1.13
@@ -0,0 +1,216 @@
+package linker
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// headerMarker 二进制header的第一个字节
+const headerMarker = 0x00
+
+var errMalformedHeader = errors.New("linker: malformed header")
+
+// Header 请求和回复的属性, 一个key可以有多个值, key区分大小写.
+//
+// 编码格式: marker, key的数量, 然后每个key依次是key的长度, key, value的数量, 每个value的长度和value,
+// 所有的数量和长度都是uvarint
+type Header map[string][]string
+
+// DecodeHeader 按照数据包的协议版本解析header, v2数据包使用二进制格式, v1数据包使用旧的"k=v;"格式
+func DecodeHeader(data []byte, version uint8) (Header, error) {
+	if version < ProtocolV2 {
+		return decodeLegacyHeader(data), nil
+	}
+
+	h := make(Header)
+	if len(data) == 0 {
+		return h, nil
+	}
+
+	if data[0] != headerMarker {
+		return nil, errMalformedHeader
+	}
+
+	data = data[1:]
+
+	count, err := readUvarint(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint64(0); i < count; i++ {
+		key, err := readString(&data)
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := readUvarint(&data)
+		if err != nil {
+			return nil, err
+		}
+
+		// 每个value至少占一个字节, 防止伪造的数量导致分配过多的内存
+		if n > uint64(len(data)) {
+			return nil, errMalformedHeader
+		}
+
+		values := make([]string, 0, n)
+		for j := uint64(0); j < n; j++ {
+			v, err := readString(&data)
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, v)
+		}
+
+		h[key] = values
+	}
+
+	if len(data) != 0 {
+		return nil, errMalformedHeader
+	}
+
+	return h, nil
+}
+
+// decodeLegacyHeader 解析旧的"k=v;"格式, 同一个key只保留第一个值
+func decodeLegacyHeader(data []byte) Header {
+	h := make(Header)
+	for _, kv := range strings.Split(string(data), ";") {
+		if kv == "" {
+			continue
+		}
+
+		s := strings.SplitN(kv, "=", 2)
+		if len(s) == 1 {
+			s = append(s, "")
+		}
+
+		if _, ok := h[s[0]]; !ok {
+			h[s[0]] = []string{s[1]}
+		}
+	}
+
+	return h
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+
+	return append(buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+func readUvarint(data *[]byte) (uint64, error) {
+	v, n := binary.Uvarint(*data)
+	if n <= 0 {
+		return 0, errMalformedHeader
+	}
+
+	*data = (*data)[n:]
+
+	return v, nil
+}
+
+func readString(data *[]byte) (string, error) {
+	l, err := readUvarint(data)
+	if err != nil {
+		return "", err
+	}
+
+	if l > uint64(len(*data)) {
+		return "", errMalformedHeader
+	}
+
+	s := string((*data)[:l])
+	*data = (*data)[l:]
+
+	return s, nil
+}
+
+// Get 返回key的第一个值
+func (h Header) Get(key string) string {
+	if v := h[key]; len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+// Values 返回key的所有值
+func (h Header) Values(key string) []string {
+	return h[key]
+}
+
+// Set 用value替换key已有的值
+func (h Header) Set(key, value string) {
+	h[key] = []string{value}
+}
+
+// Add 为key添加一个值
+func (h Header) Add(key, value string) {
+	h[key] = append(h[key], value)
+}
+
+// Del 删除key的所有值
+func (h Header) Del(key string) {
+	delete(h, key)
+}
+
+// Clone 返回header的副本
+func (h Header) Clone() Header {
+	c := make(Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+
+	return c
+}
+
+// keys 排序以后的key, 保证编码结果稳定
+func (h Header) keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Encode 使用二进制格式编码, 空的header编码为空
+func (h Header) Encode() []byte {
+	if len(h) == 0 {
+		return nil
+	}
+
+	buf := []byte{headerMarker}
+	buf = appendUvarint(buf, uint64(len(h)))
+	for _, k := range h.keys() {
+		buf = appendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = appendUvarint(buf, uint64(len(h[k])))
+		for _, v := range h[k] {
+			buf = appendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		}
+	}
+
+	return buf
+}
+
+// EncodeLegacy 使用旧的"k=v;"格式编码, 用于不支持二进制header的客户端,
+// 每个key只保留第一个值, key和value中的"="和";"无法表示
+func (h Header) EncodeLegacy() []byte {
+	var buf []byte
+	for _, k := range h.keys() {
+		if v := h[k]; len(v) > 0 {
+			buf = append(buf, k+"="+v[0]+";"...)
+		}
+	}
+
+	return buf
+}
@@ -0,0 +1,47 @@
+package linker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHeaderEncode(t *testing.T) {
+	h := Header{"message": {"a=b;c"}, "accept": {"json", "xml"}, "empty": {""}}
+
+	got, err := DecodeHeader(h.Encode(), ProtocolV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, h) {
+		t.Errorf("unexpected header: %v", got)
+	}
+
+	if _, err := DecodeHeader(h.Encode()[:5], ProtocolV2); err == nil {
+		t.Error("expected truncated header to fail")
+	}
+
+	// v2数据包的header必须是二进制格式, 空的header没有任何属性
+	if _, err := DecodeHeader([]byte("v=1.0;"), ProtocolV2); err == nil {
+		t.Error("expected legacy header in a v2 packet to fail")
+	}
+
+	if got, err := DecodeHeader(nil, ProtocolV2); err != nil || len(got) != 0 {
+		t.Errorf("unexpected empty header: %v %v", got, err)
+	}
+}
+
+func TestHeaderLegacy(t *testing.T) {
+	got, err := DecodeHeader([]byte("v=1.0;timeout=100;token=abc=="), ProtocolV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Get("v") != "1.0" || got.Get("timeout") != "100" || got.Get("token") != "abc==" {
+		t.Errorf("unexpected header: %v", got)
+	}
+
+	if s := string(got.EncodeLegacy()); s != "timeout=100;token=abc==;v=1.0;" {
+		t.Errorf("unexpected legacy encoding: %s", s)
+	}
+}
@@ -1,24 +1,62 @@
 package linker
 
 import (
+	"crypto/tls"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 // fix panic as websocket concurrency write
 type webSocketConn struct {
-	mutex sync.Mutex
-	conn  *websocket.Conn
+	mutex    sync.Mutex
+	conn     *websocket.Conn
+	timeout  time.Duration
+	limits   FrameLimits
+	tlsState *tls.ConnectionState
+	// 读取使用的协议版本, 只在读循环中访问
+	version uint8
+}
+
+func newWebSocketConn(conn *websocket.Conn, options Options) *webSocketConn {
+	return &webSocketConn{conn: conn, timeout: options.timeout, limits: options.frameLimits()}
+}
+
+// ReadPacket 每个websocket消息是一个完整的数据包
+func (ws *webSocketConn) ReadPacket() (Packet, error) {
+	_, r, err := ws.conn.NextReader()
+	if err != nil {
+		return Packet{}, err
+	}
+
+	return ReadPacket(r, ws.version, ws.limits)
+}
+
+func (ws *webSocketConn) setProtocol(version uint8) {
+	ws.version = version
+}
+
+func (ws *webSocketConn) WritePacket(p Packet) error {
+	return ws.WriteMessage(websocket.BinaryMessage, p.Bytes())
 }
 
 func (ws *webSocketConn) WriteMessage(messageType int, data []byte) error {
 	ws.mutex.Lock()
-	err := ws.conn.WriteMessage(messageType, data)
-	ws.mutex.Unlock()
+	defer ws.mutex.Unlock()
+
+	if ws.timeout != 0 {
+		if err := ws.conn.SetWriteDeadline(time.Now().Add(ws.timeout)); err != nil {
+			return err
+		}
+	}
+
+	return ws.conn.WriteMessage(messageType, data)
+}
 
-	return err
+func (ws *webSocketConn) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
 }
 
 func (ws *webSocketConn) LocalAddr() net.Addr {
@@ -28,3 +66,12 @@ func (ws *webSocketConn) LocalAddr() net.Addr {
 func (ws *webSocketConn) RemoteAddr() net.Addr {
 	return ws.conn.RemoteAddr()
 }
+
+// TLSState 升级请求的TLS状态, 没有使用https时返回nil
+func (ws *webSocketConn) TLSState() *tls.ConnectionState {
+	return ws.tlsState
+}
+
+func (ws *webSocketConn) Close() error {
+	return ws.conn.Close()
+}
@@ -1,250 +1,133 @@
 package linker
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
-	"sync"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	uuid "github.com/satori/go.uuid"
-
 	"github.com/gorilla/websocket"
-	"github.com/wpajqz/linker/utils/convert"
 )
 
-func (s *Server) handleWebSocketConnection(conn *websocket.Conn) error {
-	wsn := &webSocketConn{mutex: sync.Mutex{}, conn: conn}
-	var ctx = &ContextWebsocket{Conn: wsn}
+// webSocketTransport http传输层, 在http服务的路由上升级websocket连接
+type webSocketTransport struct {
+	address   string
+	wsRoute   string
+	handler   http.Handler
+	tlsConfig *TLSConfig
+	options   Options
+	server    *http.Server
+	listener  net.Listener
+}
 
-	if s.options.constructHandler != nil {
-		s.options.constructHandler.Handle(ctx)
+func newWebSocketTransport(endpoint *Endpoint, options Options) *webSocketTransport {
+	return &webSocketTransport{
+		address:   endpoint.Address,
+		wsRoute:   endpoint.WSRoute,
+		handler:   endpoint.Handler,
+		tlsConfig: endpoint.TLS,
+		options:   options,
 	}
+}
 
-	ctx.Set(nodeID, uuid.NewV4().String())
-
-	defer func() {
-		if s.options.destructHandler != nil {
-			s.options.destructHandler.Handle(ctx)
-		}
-
-		if err := ctx.UnSubscribeAll(); err != nil {
-			ctx.Error(StatusInternalServerError, err.Error())
-		}
-
-		_ = conn.Close()
-	}()
-
-	var (
-		bType         = make([]byte, 4)
-		bSequence     = make([]byte, 8)
-		bHeaderLength = make([]byte, 4)
-		bBodyLength   = make([]byte, 4)
-		sequence      int64
-		headerLength  uint32
-		bodyLength    uint32
-	)
-
-	for {
-		if s.options.timeout != 0 {
-			err := conn.SetReadDeadline(time.Now().Add(s.options.timeout))
-			if err != nil {
-				return err
-			}
-
-			err = conn.SetWriteDeadline(time.Now().Add(s.options.timeout))
-			if err != nil {
-				return err
-			}
-		}
-
-		_, r, err := conn.NextReader()
-		if err != nil {
-			return err
-		}
-
-		if _, err := io.ReadFull(r, bType); err != nil {
-			return err
-		}
-
-		if _, err := io.ReadFull(r, bSequence); err != nil {
-			return err
-		}
-
-		if _, err := io.ReadFull(r, bHeaderLength); err != nil {
-			return err
-		}
-
-		if _, err := io.ReadFull(r, bBodyLength); err != nil {
-			return err
-		}
-
-		sequence = convert.BytesToInt64(bSequence)
-		headerLength = convert.BytesToUint32(bHeaderLength)
-		bodyLength = convert.BytesToUint32(bBodyLength)
-
-		header := make([]byte, headerLength)
-		if _, err := io.ReadFull(r, header); err != nil {
-			return err
-
-		}
-
-		body := make([]byte, bodyLength)
-		if _, err := io.ReadFull(r, body); err != nil {
-			return err
-		}
-
-		rp, err := NewPacket(convert.BytesToUint32(bType), sequence, header, body, s.options.pluginForPacketReceiver)
+func (t *webSocketTransport) Listen() error {
+	switch t.handler.(type) {
+	case *gin.Engine, nil:
+	default:
+		return errors.New("unsupported http's handler")
+	}
 
+	t.server = &http.Server{Addr: t.address, Handler: t.handler}
+	if t.tlsConfig != nil {
+		config, err := t.tlsConfig.build()
 		if err != nil {
 			return err
 		}
 
-		ctx = NewContextWebsocket(ctx.Context, wsn, rp.Operator, rp.Sequence, rp.Header, rp.Body, s.options)
-		go s.handleWebSocketPacket(ctx, conn, rp)
+		t.server.TLSConfig = config
 	}
-}
-
-func (s *Server) handleWebSocketPacket(ctx Context, conn *websocket.Conn, rp Packet) {
-	defer func() {
-		if r := recover(); r != nil {
-			var errMsg string
 
-			switch v := r.(type) {
-			case string:
-				errMsg = v
-			case error:
-				errMsg = v.Error()
-			default:
-				errMsg = StatusText(StatusInternalServerError)
-			}
+	var err error
+	t.listener, err = net.Listen(NetworkTCP, t.address)
+	if err != nil {
+		return err
+	}
 
-			ctx.Set(errorTag, errMsg)
+	if t.server.TLSConfig != nil {
+		fmt.Printf("Listening and serving HTTPS on %s\n", t.listener.Addr())
+	} else {
+		fmt.Printf("Listening and serving HTTP on %s\n", t.listener.Addr())
+	}
 
-			if s.options.errorHandler != nil {
-				s.options.errorHandler.Handle(ctx)
-			}
+	return nil
+}
 
-			ctx.Error(StatusInternalServerError, errMsg)
-		}
-	}()
+func (t *webSocketTransport) Serve(handler func(Conn)) error {
+	switch r := t.handler.(type) {
+	case *gin.Engine:
+		r.GET(t.wsRoute, func(ctx *gin.Context) {
+			t.upgrade(ctx.Writer, ctx.Request, handler)
+		})
 
-	if rp.Operator == OperatorHeartbeat {
-		if s.options.pingHandler != nil {
-			s.options.pingHandler.Handle(ctx)
-		}
+		//	match old version
+		r.GET(t.wsRoute+"/websocket", func(ctx *gin.Context) {
+			t.upgrade(ctx.Writer, ctx.Request, handler)
+		})
+	case nil:
+		http.HandleFunc(t.wsRoute, func(w http.ResponseWriter, r *http.Request) {
+			t.upgrade(w, r, handler)
+		})
+	}
 
-		ctx.Success(nil)
+	var err error
+	if t.server.TLSConfig != nil {
+		// 证书已经在TLSConfig中
+		err = t.server.ServeTLS(t.listener, "", "")
+	} else {
+		err = t.server.Serve(t.listener)
 	}
 
-	handler, ok := s.router.handlerContainer[rp.Operator]
-	if !ok {
-		ctx.Error(StatusInternalServerError, "server don't register your request.")
+	if err == http.ErrServerClosed {
+		return ErrServerClosed
 	}
 
-	if rm, ok := s.router.routerMiddleware[rp.Operator]; ok {
-		for _, v := range rm {
-			ctx = v.Handle(ctx)
-		}
+	return err
+}
+
+// upgrade 把http请求升级为websocket连接
+func (t *webSocketTransport) upgrade(w http.ResponseWriter, r *http.Request, handler func(Conn)) {
+	var upgrade = websocket.Upgrader{
+		HandshakeTimeout:  t.options.timeout,
+		ReadBufferSize:    t.options.readBufferSize,
+		WriteBufferSize:   t.options.writeBufferSize,
+		EnableCompression: true,
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
 	}
 
-	for _, v := range s.router.middleware {
-		ctx = v.Handle(ctx)
-		if tm, ok := v.(TerminateMiddleware); ok {
-			tm.Terminate(ctx)
-		}
+	conn, err := upgrade.Upgrade(w, r, nil)
+	if err != nil {
+		return
 	}
 
-	handler.Handle(ctx)
-	ctx.Success(nil) // If it don't call the function of Success or Error, deal it by default
-}
+	ws := newWebSocketConn(conn, t.options)
+	ws.tlsState = r.TLS
 
-// runHTTP 开始运行HTTP服务
-func (s *Server) runHTTP(address, wsRoute string, handler http.Handler) error {
-	switch r := handler.(type) {
-	case *gin.Engine:
-		r.GET(wsRoute, func(ctx *gin.Context) {
-			var upgrade = websocket.Upgrader{
-				HandshakeTimeout:  s.options.timeout,
-				ReadBufferSize:    s.options.readBufferSize,
-				WriteBufferSize:   s.options.writeBufferSize,
-				EnableCompression: true,
-				CheckOrigin: func(r *http.Request) bool {
-					return true
-				},
-			}
-
-			conn, err := upgrade.Upgrade(ctx.Writer, ctx.Request, nil)
-			if err != nil {
-				ctx.String(http.StatusBadRequest, err.Error())
-				return
-			}
-
-			go func(conn *websocket.Conn) {
-				err := s.handleWebSocketConnection(conn)
-				if err != nil && err != io.EOF {
-					fmt.Printf("websocket connection error: %s\n", err.Error())
-				}
-			}(conn)
-		})
+	go handler(ws)
+}
 
-		//	match old version
-		r.GET(wsRoute+"/websocket", func(ctx *gin.Context) {
-			var upgrade = websocket.Upgrader{
-				HandshakeTimeout:  s.options.timeout,
-				ReadBufferSize:    s.options.readBufferSize,
-				WriteBufferSize:   s.options.writeBufferSize,
-				EnableCompression: true,
-				CheckOrigin: func(r *http.Request) bool {
-					return true
-				},
-			}
-
-			conn, err := upgrade.Upgrade(ctx.Writer, ctx.Request, nil)
-			if err != nil {
-				ctx.String(http.StatusBadRequest, err.Error())
-				return
-			}
-
-			go func(conn *websocket.Conn) {
-				err := s.handleWebSocketConnection(conn)
-				if err != nil && err != io.EOF {
-					fmt.Printf("websocket connection error: %s\n", err.Error())
-				}
-			}(conn)
-		})
-	case nil:
-		http.HandleFunc(wsRoute, func(w http.ResponseWriter, r *http.Request) {
-			var upgrade = websocket.Upgrader{
-				HandshakeTimeout:  s.options.timeout,
-				ReadBufferSize:    s.options.readBufferSize,
-				WriteBufferSize:   s.options.writeBufferSize,
-				EnableCompression: true,
-				CheckOrigin: func(r *http.Request) bool {
-					return true
-				},
-			}
-
-			conn, err := upgrade.Upgrade(w, r, nil)
-			if err != nil {
-				w.Write([]byte(err.Error()))
-				return
-			}
-
-			go func(conn *websocket.Conn) {
-				err := s.handleWebSocketConnection(conn)
-				if err != nil && err != io.EOF {
-					fmt.Printf("websocket connection error: %s\n", err.Error())
-				}
-			}(conn)
-		})
-	default:
-		return errors.New("unsupported http's handler")
-	}
+func (t *webSocketTransport) Close() error {
+	return t.server.Close()
+}
 
-	fmt.Printf("Listening and serving HTTP on %s\n", address)
+// Shutdown 等待普通的http请求处理完成, 升级为websocket的连接已经被hijack, 由Server负责关闭
+func (t *webSocketTransport) Shutdown(ctx context.Context) error {
+	return t.server.Shutdown(ctx)
+}
 
-	return http.ListenAndServe(address, handler)
+func (t *webSocketTransport) Addr() net.Addr {
+	return t.listener.Addr()
 }
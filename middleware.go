@@ -1,12 +1,17 @@
 package linker
 
-// 全局中间件,每个请求都有执行的操作
-type Middleware interface {
-	Handle(Context) Context
-}
+// Middleware 包装Handler的中间件, 在调用next之前或之后执行操作, 不调用next即可中断请求.
+// 执行顺序为全局中间件, 路由中间件, 最后是处理器, 调用next返回以后可以通过StatusCode检查回复.
+// 需要在回复被发送到客户端以后执行的操作通过Context.OnResponse注册
+type Middleware func(next Handler) Handler
+
+// chain 按顺序把中间件包装到处理器上, 第一个中间件在最外层
+func chain(handler Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		if middleware[i] != nil {
+			handler = middleware[i](handler)
+		}
+	}
 
-// 响应数据被发送到客户端以后需要执行的操作
-type TerminateMiddleware interface {
-	Handle(Context) Context
-	Terminate(Context)
+	return handler
 }
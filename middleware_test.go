@@ -0,0 +1,124 @@
+package linker_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+)
+
+func TestMiddleware(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		trace []string
+	)
+
+	record := func(s string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		trace = append(trace, s)
+	}
+
+	mark := func(name string) linker.Middleware {
+		return func(next linker.Handler) linker.Handler {
+			return linker.HandlerFunc(func(ctx linker.Context) {
+				record(name + ">")
+				next.Handle(ctx)
+				record(name + "<")
+			})
+		}
+	}
+
+	// 回复发送以后记录最终的状态码
+	codes := make(chan int, 1)
+	status := func(next linker.Handler) linker.Handler {
+		return linker.HandlerFunc(func(ctx linker.Context) {
+			ctx.OnResponse(func(ctx linker.Context) { codes <- ctx.StatusCode() })
+			next.Handle(ctx)
+		})
+	}
+
+	auth := func(next linker.Handler) linker.Handler {
+		return linker.HandlerFunc(func(ctx linker.Context) {
+			record("auth")
+			if ctx.GetRequestProperty("token") == "" {
+				ctx.Error(linker.StatusUnauthorized, "unauthorized")
+				return
+			}
+
+			next.Handle(ctx)
+		})
+	}
+
+	router := linker.NewRouter().Use(status, mark("global"))
+	router.Route("/users", linker.HandlerFunc(func(ctx linker.Context) { record("handler") }), auth, mark("route"))
+	router.Route("/panic", linker.HandlerFunc(func(ctx linker.Context) { panic("boom") }))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	conn, err := net.Dial(linker.NetworkTCP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+	serve := func(pattern string, header []byte) (linker.Packet, int, string) {
+		t.Helper()
+
+		mutex.Lock()
+		trace = nil
+		mutex.Unlock()
+
+		writeRequest(t, conn, pattern, 1, header, nil)
+		p := readReply(t, conn)
+
+		var code int
+		select {
+		case code = <-codes:
+		case <-time.After(time.Second):
+			t.Fatal("response hook was not called")
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return p, code, strings.Join(trace, ",")
+	}
+
+	// 中间件按照全局, 路由的顺序包住处理器, 处理器没有回复时默认成功
+	_, code, got := serve("/users", []byte("token=abc;"))
+	if got != "global>,auth,route>,handler,route<,global<" {
+		t.Errorf("unexpected middleware order: %s", got)
+	}
+
+	if code != linker.StatusOK {
+		t.Errorf("unexpected status in response hook: %d", code)
+	}
+
+	// 中间件不调用next时处理器和之后的中间件都不执行
+	p, code, got := serve("/users", nil)
+	if got != "global>,auth,global<" {
+		t.Errorf("unexpected short-circuit chain: %s", got)
+	}
+
+	if !strings.Contains(string(p.Header), "code=401;") {
+		t.Errorf("unexpected reply header: %s", p.Header)
+	}
+
+	if code != linker.StatusUnauthorized {
+		t.Errorf("unexpected status in response hook: %d", code)
+	}
+
+	// 处理器panic以后回调看到的是恢复以后的状态码
+	if _, code, _ := serve("/panic", nil); code != linker.StatusInternalServerError {
+		t.Errorf("unexpected status in response hook: %d", code)
+	}
+}
@@ -2,11 +2,13 @@ package linker
 
 import (
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/wpajqz/linker/api"
 	"github.com/wpajqz/linker/broker"
 	"github.com/wpajqz/linker/plugin"
+	"github.com/wpajqz/linker/presence"
 )
 
 type (
@@ -15,20 +17,31 @@ type (
 		readBufferSize                                               int
 		writeBufferSize                                              int
 		udpPayload                                                   int
+		maxUDPSessions                                               int
 		timeout                                                      time.Duration
 		contentType                                                  string
 		broker                                                       broker.Broker
+		presence                                                     presence.Presence
+		presenceHandler                                              func(presence.Event)
+		cluster                                                      bool
+		service, advertise                                           string
 		api                                                          api.API
 		pluginForPacketSender                                        []plugin.PacketPlugin
 		pluginForPacketReceiver                                      []plugin.PacketPlugin
 		errorHandler, constructHandler, destructHandler, pingHandler Handler
-		httpEndpoint, tcpEndpoint, udpEndpoint                       *Endpoint
+		joinHandler, leaveHandler                                    RoomHandler
+		httpEndpoint, tcpEndpoint, udpEndpoint, unixEndpoint         *Endpoint
+		unixPermission                                               os.FileMode
+		maxHeaderSize, maxBodySize, maxFrameSize                     int
+		transports                                                   []Transport
 	}
 
 	Endpoint struct {
 		Address string
 		WSRoute string
 		Handler http.Handler
+		// 不为空时tcp和websocket使用TLS
+		TLS *TLSConfig
 	}
 
 	Option func(o *Options)
@@ -58,6 +71,34 @@ func UDPPayload(size int) Option {
 	}
 }
 
+// MaxUDPSessions udp同时存在的会话数量上限, 默认defaultMaxUDPSessions, 超过时关闭最久没有收到数据的会话
+func MaxUDPSessions(n int) Option {
+	return func(o *Options) {
+		o.maxUDPSessions = n
+	}
+}
+
+// MaxHeaderSize 请求header的最大长度, 默认DefaultMaxHeaderSize
+func MaxHeaderSize(size int) Option {
+	return func(o *Options) {
+		o.maxHeaderSize = size
+	}
+}
+
+// MaxBodySize 请求body的最大长度, 默认DefaultMaxBodySize
+func MaxBodySize(size int) Option {
+	return func(o *Options) {
+		o.maxBodySize = size
+	}
+}
+
+// MaxFrameSize 整个数据包的最大长度, 默认只限制header和body
+func MaxFrameSize(size int) Option {
+	return func(o *Options) {
+		o.maxFrameSize = size
+	}
+}
+
 func Timeout(d time.Duration) Option {
 	return func(o *Options) {
 		o.timeout = d
@@ -82,6 +123,42 @@ func Broker(broker broker.Broker) Option {
 	}
 }
 
+// Presence 在集群中记录在线的节点, 多个服务实例使用同一个后端
+func Presence(p presence.Presence) Option {
+	return func(o *Options) {
+		o.presence = p
+	}
+}
+
+// WithOnPresence 集群中的节点上线或者下线时的回调, 需要配置Presence
+func WithOnPresence(handler func(presence.Event)) Option {
+	return func(o *Options) {
+		o.presenceHandler = handler
+	}
+}
+
+// WithCluster 开启集群模式, 使用同一个Broker的实例互相发现,
+// 本地找不到的节点的SendTo和Call转发给其它实例
+func WithCluster() Option {
+	return func(o *Options) {
+		o.cluster = true
+	}
+}
+
+// WithService Run时把tcp地址注册到Broker的服务name下, 客户端通过resolver/broker发现, Shutdown时注销
+func WithService(name string) Option {
+	return func(o *Options) {
+		o.service = name
+	}
+}
+
+// AdvertiseAddress 注册到Broker的地址, 默认使用tcp监听的地址, 监听0.0.0.0等地址时必须设置, 否则Run返回错误
+func AdvertiseAddress(address string) Option {
+	return func(o *Options) {
+		o.advertise = address
+	}
+}
+
 func PluginForPacketSender(plugins ...plugin.PacketPlugin) Option {
 	return func(o *Options) {
 		o.pluginForPacketSender = append(o.pluginForPacketSender, plugins...)
@@ -118,6 +195,20 @@ func WithOnPing(handler Handler) Option {
 	}
 }
 
+// WithOnJoin 连接加入房间时的回调, 只在连接所在的实例上触发
+func WithOnJoin(handler RoomHandler) Option {
+	return func(o *Options) {
+		o.joinHandler = handler
+	}
+}
+
+// WithOnLeave 连接离开房间时的回调, 连接关闭时自动离开的房间也会触发. 只在连接所在的实例上触发
+func WithOnLeave(handler RoomHandler) Option {
+	return func(o *Options) {
+		o.leaveHandler = handler
+	}
+}
+
 func WithHTTPEndpoint(e Endpoint) Option {
 	return func(o *Options) {
 		o.httpEndpoint = &e
@@ -135,3 +226,29 @@ func WithUDPEndpoint(e Endpoint) Option {
 		o.udpEndpoint = &e
 	}
 }
+
+// WithUnixEndpoint 在unix domain socket上监听, 已经存在但是没有进程监听的socket文件会被删除
+func WithUnixEndpoint(path string) Option {
+	return func(o *Options) {
+		o.unixEndpoint = &Endpoint{Address: path}
+	}
+}
+
+// UnixSocketPermission 设置unix socket文件的权限, 比如0660只允许同组的进程连接
+func UnixSocketPermission(mode os.FileMode) Option {
+	return func(o *Options) {
+		o.unixPermission = mode
+	}
+}
+
+// WithTransport 添加自定义的传输层, 和内置的tcp, http, udp一起运行
+func WithTransport(t Transport) Option {
+	return func(o *Options) {
+		o.transports = append(o.transports, t)
+	}
+}
+
+// frameLimits 根据配置生成数据包的大小限制
+func (o Options) frameLimits() FrameLimits {
+	return FrameLimits{MaxHeaderSize: o.maxHeaderSize, MaxBodySize: o.maxBodySize, MaxFrameSize: o.maxFrameSize}
+}
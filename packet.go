@@ -1,14 +1,81 @@
 package linker
 
 import (
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 
 	"github.com/wpajqz/linker/plugin"
 	"github.com/wpajqz/linker/utils/convert"
 )
 
+// 数据包固定部分的长度
+// v1: operator, sequence, header length, body length
+// v2: magic, version, flags, 然后和v1相同
+const (
+	packetHeadLength   = 20
+	packetHeadLengthV2 = 24
+	checksumLength     = 4
+)
+
+// 协议版本, 连接建立以后使用v1握手协商, 之后双方都按照协商的版本读写, 不再逐个数据包判断
+const (
+	ProtocolV1 = 1
+	ProtocolV2 = 2
+)
+
+// packetMagic v2数据包开头的两个字节"LK", 用来检查数据流是否错位
+const packetMagic = 0x4C4B
+
+// v2数据包的flags, FlagCompressed和FlagEncrypted是保留的, 目前还不支持, 带这两个flag的数据包会被拒绝
+const (
+	FlagCompressed  uint8 = 1 << iota // body经过压缩
+	FlagEncrypted                     // header和body经过加密
+	FlagStream                        // 流式消息
+	FlagEndOfStream                   // 流的最后一个消息
+	FlagOneWay                        // 不需要回复
+	FlagChecksum                      // 数据包末尾有CRC32C校验值
+	FlagControl                       // 控制消息, 比如取消正在处理的请求, 不交给路由处理
+	FlagOpenStream                    // 客户端打开双向流, 之后带FlagStream的数据包都属于这个流
+)
+
+// unsupportedFlags 还没有实现的flags
+const unsupportedFlags = FlagCompressed | FlagEncrypted
+
+// 默认的数据包大小限制
+const (
+	DefaultMaxHeaderSize = 1 << 20
+	DefaultMaxBodySize   = 32 << 20
+)
+
+var (
+	errMalformedPacket = errors.New("malformed packet")
+	// ErrFrameTooLarge 数据包的header, body或者总长度超过了限制
+	ErrFrameTooLarge = errors.New("linker: frame too large")
+	// ErrChecksum 数据包的CRC32C校验失败
+	ErrChecksum = errors.New("linker: checksum mismatch")
+	// ErrUnsupportedProtocol 不支持的协议版本
+	ErrUnsupportedProtocol = errors.New("linker: unsupported protocol version")
+	// ErrUnsupportedFlags 数据包带有还不支持的flags, 比如压缩和加密
+	ErrUnsupportedFlags = errors.New("linker: unsupported packet flags")
+
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+)
+
 type (
+	// FrameLimits 数据包的大小限制, 在分配内存之前检查长度字段.
+	// 小于等于0时使用默认值, MaxFrameSize为0时不限制总长度
+	FrameLimits struct {
+		MaxHeaderSize int
+		MaxBodySize   int
+		MaxFrameSize  int
+	}
+
 	Packet struct {
+		// 协议版本, 0和ProtocolV1都按照v1编码
+		Version      uint8
+		Flags        uint8
 		Operator     uint32
 		Sequence     int64
 		HeaderLength uint32
@@ -44,6 +111,11 @@ func NewPacket(operator uint32, sequence int64, header, body []byte, plugins []p
 
 // 得到序列化后的Packet
 func (p Packet) Bytes() (buf []byte) {
+	if p.Version >= ProtocolV2 {
+		buf = append(buf, convert.Uint16ToBytes(packetMagic)...)
+		buf = append(buf, p.Version, p.Flags)
+	}
+
 	buf = append(buf, convert.Uint32ToBytes(p.Operator)...)
 	buf = append(buf, convert.Int64ToBytes(p.Sequence)...)
 	buf = append(buf, convert.Uint32ToBytes(p.HeaderLength)...)
@@ -51,5 +123,175 @@ func (p Packet) Bytes() (buf []byte) {
 	buf = append(buf, p.Header...)
 	buf = append(buf, p.Body...)
 
+	if p.Version >= ProtocolV2 && p.Flags&FlagChecksum != 0 {
+		buf = append(buf, convert.Uint32ToBytes(crc32.Checksum(buf, castagnoli))...)
+	}
+
 	return buf
 }
+
+// size 序列化后的长度
+func (p Packet) size() int {
+	if p.Version < ProtocolV2 {
+		return packetHeadLength + int(p.HeaderLength) + int(p.BodyLength)
+	}
+
+	n := packetHeadLengthV2 + int(p.HeaderLength) + int(p.BodyLength)
+	if p.Flags&FlagChecksum != 0 {
+		n += checksumLength
+	}
+
+	return n
+}
+
+// check 检查长度字段, 超过限制时返回ErrFrameTooLarge
+func (l FrameLimits) check(headerLength, bodyLength uint32) error {
+	maxHeaderSize, maxBodySize := uint64(DefaultMaxHeaderSize), uint64(DefaultMaxBodySize)
+	if l.MaxHeaderSize > 0 {
+		maxHeaderSize = uint64(l.MaxHeaderSize)
+	}
+
+	if l.MaxBodySize > 0 {
+		maxBodySize = uint64(l.MaxBodySize)
+	}
+
+	if uint64(headerLength) > maxHeaderSize || uint64(bodyLength) > maxBodySize {
+		return ErrFrameTooLarge
+	}
+
+	if l.MaxFrameSize > 0 && packetHeadLengthV2+uint64(headerLength)+uint64(bodyLength) > uint64(l.MaxFrameSize) {
+		return ErrFrameTooLarge
+	}
+
+	return nil
+}
+
+// ReadPacket 按照连接协商的版本从流中读取一个完整的数据包, version小于ProtocolV2时按照v1读取.
+// 超过大小限制, 校验失败或者带有不支持的flags时返回的Packet只包含version, operator和sequence.
+// 不支持的flags在读完整个数据包以后才返回错误, 连接可以继续读取下一个数据包
+func ReadPacket(r io.Reader, version uint8, limits FrameLimits) (Packet, error) {
+	head := make([]byte, packetHeadLength)
+	if version >= ProtocolV2 {
+		head = make([]byte, packetHeadLengthV2)
+	}
+
+	if _, err := io.ReadFull(r, head); err != nil {
+		return Packet{}, err
+	}
+
+	var p Packet
+	if version >= ProtocolV2 {
+		if convert.BytesToUint16(head[0:2]) != packetMagic {
+			return Packet{}, errMalformedPacket
+		}
+
+		p.Version, p.Flags = head[2], head[3]
+		if p.Version != ProtocolV2 {
+			return Packet{}, ErrUnsupportedProtocol
+		}
+	}
+
+	fixed := head[len(head)-packetHeadLength:]
+	p.Operator = convert.BytesToUint32(fixed[0:4])
+	p.Sequence = convert.BytesToInt64(fixed[4:12])
+	p.HeaderLength = convert.BytesToUint32(fixed[12:16])
+	p.BodyLength = convert.BytesToUint32(fixed[16:20])
+
+	if err := limits.check(p.HeaderLength, p.BodyLength); err != nil {
+		return Packet{Version: p.Version, Operator: p.Operator, Sequence: p.Sequence}, err
+	}
+
+	p.Header = make([]byte, p.HeaderLength)
+	if _, err := io.ReadFull(r, p.Header); err != nil {
+		return Packet{}, err
+	}
+
+	p.Body = make([]byte, p.BodyLength)
+	if _, err := io.ReadFull(r, p.Body); err != nil {
+		return Packet{}, err
+	}
+
+	if p.Flags&FlagChecksum != 0 {
+		sum := make([]byte, checksumLength)
+		if _, err := io.ReadFull(r, sum); err != nil {
+			return Packet{}, err
+		}
+
+		crc := crc32.Update(crc32.Update(crc32.Checksum(head, castagnoli), castagnoli, p.Header), castagnoli, p.Body)
+		if crc != convert.BytesToUint32(sum) {
+			return Packet{Version: p.Version, Operator: p.Operator, Sequence: p.Sequence}, ErrChecksum
+		}
+	}
+
+	if p.Flags&unsupportedFlags != 0 {
+		return Packet{Version: p.Version, Operator: p.Operator, Sequence: p.Sequence}, ErrUnsupportedFlags
+	}
+
+	return p, nil
+}
+
+// ParsePacket 按照会话协商的版本解析一个数据报中的数据包, version小于ProtocolV2时按照v1解析.
+// 长度不合法, 校验失败或者带有不支持的flags时返回的Packet只包含version, operator和sequence
+func ParsePacket(data []byte, version uint8, limits FrameLimits) (Packet, error) {
+	var p Packet
+
+	headLength := packetHeadLength
+	if version >= ProtocolV2 {
+		if len(data) < packetHeadLengthV2 || convert.BytesToUint16(data[0:2]) != packetMagic {
+			return Packet{}, errMalformedPacket
+		}
+
+		p.Version, p.Flags = data[2], data[3]
+		if p.Version != ProtocolV2 {
+			return Packet{}, ErrUnsupportedProtocol
+		}
+
+		headLength = packetHeadLengthV2
+	}
+
+	if len(data) < headLength {
+		return Packet{}, errMalformedPacket
+	}
+
+	fixed := data[headLength-packetHeadLength : headLength]
+	p.Operator = convert.BytesToUint32(fixed[0:4])
+	p.Sequence = convert.BytesToInt64(fixed[4:12])
+	p.HeaderLength = convert.BytesToUint32(fixed[12:16])
+
+	payload := data[headLength:]
+	if p.Flags&FlagChecksum != 0 {
+		if len(payload) < checksumLength {
+			return Packet{Version: p.Version, Operator: p.Operator, Sequence: p.Sequence}, ErrFrameTooLarge
+		}
+
+		end := len(data) - checksumLength
+		if crc32.Checksum(data[:end], castagnoli) != convert.BytesToUint32(data[end:]) {
+			return Packet{Version: p.Version, Operator: p.Operator, Sequence: p.Sequence}, ErrChecksum
+		}
+
+		payload = payload[:len(payload)-checksumLength]
+	}
+
+	// 数据报被截断时header length会超过实际的长度
+	if uint64(p.HeaderLength) > uint64(len(payload)) {
+		return Packet{Version: p.Version, Operator: p.Operator, Sequence: p.Sequence}, ErrFrameTooLarge
+	}
+
+	p.Header = payload[:p.HeaderLength]
+	p.Body = payload[p.HeaderLength:]
+	p.BodyLength = uint32(len(p.Body))
+
+	if bl := convert.BytesToUint32(fixed[16:20]); p.Version >= ProtocolV2 && bl != p.BodyLength {
+		return Packet{Version: p.Version, Operator: p.Operator, Sequence: p.Sequence}, ErrFrameTooLarge
+	}
+
+	if err := limits.check(p.HeaderLength, p.BodyLength); err != nil {
+		return Packet{Version: p.Version, Operator: p.Operator, Sequence: p.Sequence}, err
+	}
+
+	if p.Flags&unsupportedFlags != 0 {
+		return Packet{Version: p.Version, Operator: p.Operator, Sequence: p.Sequence}, ErrUnsupportedFlags
+	}
+
+	return p, nil
+}
@@ -0,0 +1,86 @@
+package linker
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPacketV2Checksum(t *testing.T) {
+	p, err := NewPacket(1025, 7, []byte("k=v;"), []byte("body"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Version, p.Flags = ProtocolV2, FlagChecksum|FlagOneWay
+	data := p.Bytes()
+
+	got, err := ReadPacket(bytes.NewReader(data), ProtocolV2, FrameLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Version != ProtocolV2 || got.Flags != p.Flags || got.Operator != 1025 || got.Sequence != 7 || string(got.Body) != "body" {
+		t.Errorf("unexpected packet: %+v", got)
+	}
+
+	if _, err := ParsePacket(data, ProtocolV2, FrameLimits{}); err != nil {
+		t.Fatal(err)
+	}
+
+	data[len(data)-5] ^= 0xff
+	if _, err := ReadPacket(bytes.NewReader(data), ProtocolV2, FrameLimits{}); err != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+
+	if _, err := ParsePacket(data, ProtocolV2, FrameLimits{}); err != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+
+	// v1数据包没有magic, 按照原来的格式解析
+	v1, _ := NewPacket(1025, 7, nil, []byte("body"), nil)
+	if got, err := ReadPacket(bytes.NewReader(v1.Bytes()), ProtocolV1, FrameLimits{}); err != nil || got.Version != 0 || string(got.Body) != "body" {
+		t.Errorf("unexpected v1 packet: %+v %v", got, err)
+	}
+
+	// v2连接上没有magic的数据包不合法
+	if _, err := ReadPacket(bytes.NewReader(v1.Bytes()), ProtocolV2, FrameLimits{}); err != errMalformedPacket {
+		t.Errorf("expected errMalformedPacket, got %v", err)
+	}
+}
+
+func TestPacketUnsupportedFlags(t *testing.T) {
+	p, _ := NewPacket(1025, 7, nil, []byte("body"), nil)
+	p.Version, p.Flags = ProtocolV2, FlagCompressed|FlagChecksum
+
+	next, _ := NewPacket(1025, 8, nil, nil, nil)
+	next.Version = ProtocolV2
+
+	// 整个数据包已经读取, 可以继续读取下一个
+	r := bytes.NewReader(append(p.Bytes(), next.Bytes()...))
+	if got, err := ReadPacket(r, ProtocolV2, FrameLimits{}); err != ErrUnsupportedFlags || got.Sequence != 7 {
+		t.Errorf("expected ErrUnsupportedFlags, got %+v %v", got, err)
+	}
+
+	if got, err := ReadPacket(r, ProtocolV2, FrameLimits{}); err != nil || got.Sequence != 8 {
+		t.Errorf("unexpected next packet: %+v %v", got, err)
+	}
+
+	p.Flags = FlagEncrypted
+	if _, err := ParsePacket(p.Bytes(), ProtocolV2, FrameLimits{}); err != ErrUnsupportedFlags {
+		t.Errorf("expected ErrUnsupportedFlags, got %v", err)
+	}
+}
+
+func TestPacketV1MagicOperator(t *testing.T) {
+	// 高16位和magic相同的operator在v1连接上按照v1解析
+	p, _ := NewPacket(packetMagic<<16|ProtocolV2<<8, 3, nil, []byte("body"), nil)
+
+	got, err := ReadPacket(bytes.NewReader(p.Bytes()), ProtocolV1, FrameLimits{})
+	if err != nil || got.Operator != p.Operator || got.Sequence != 3 || string(got.Body) != "body" {
+		t.Errorf("unexpected packet: %+v %v", got, err)
+	}
+
+	if got, err := ParsePacket(p.Bytes(), ProtocolV1, FrameLimits{}); err != nil || got.Operator != p.Operator || string(got.Body) != "body" {
+		t.Errorf("unexpected packet: %+v %v", got, err)
+	}
+}
@@ -0,0 +1,35 @@
+//go:build linux
+// +build linux
+
+package linker
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials 通过SO_PEERCRED获取对端进程的身份
+func peerCredentials(conn *net.UnixConn) (*Credentials, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ucred *syscall.Ucred
+		serr  error
+	)
+
+	err = raw.Control(func(fd uintptr) {
+		ucred, serr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if serr != nil {
+		return nil, serr
+	}
+
+	return &Credentials{PID: int(ucred.Pid), UID: int(ucred.Uid), GID: int(ucred.Gid)}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package linker
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentials 目前只支持linux
+func peerCredentials(conn *net.UnixConn) (*Credentials, error) {
+	return nil, errors.New("linker: peer credentials are not supported on this platform")
+}
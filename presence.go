@@ -0,0 +1,63 @@
+package linker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/wpajqz/linker/presence"
+)
+
+// tracker 把本地连接的状态同步到Presence, 没有配置Presence时不做处理
+type tracker struct {
+	presence presence.Presence
+	instance string
+}
+
+// touch 连接建立, 心跳和标签变化时刷新节点
+func (t *tracker) touch(c *Connection) {
+	if t.presence == nil {
+		return
+	}
+
+	if err := t.presence.Touch(presence.Entry{NodeID: c.id, Instance: t.instance, Tags: c.Tags()}); err != nil {
+		fmt.Printf("presence touch error: %s\n", err.Error())
+	}
+}
+
+// remove 连接关闭时节点下线
+func (t *tracker) remove(c *Connection) {
+	if t.presence == nil {
+		return
+	}
+
+	if err := t.presence.Remove(c.id); err != nil {
+		fmt.Printf("presence remove error: %s\n", err.Error())
+	}
+}
+
+// refresh 在TTL内定期刷新所有本地连接, 没有心跳的连接也不会过期, 实例崩溃以后不再刷新
+func (t *tracker) refresh(connections *Connections, done <-chan struct{}) {
+	ticker := time.NewTicker(t.presence.TTL() / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, c := range connections.All() {
+				t.touch(c)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Presence 服务端配置的Presence, 用于查询集群中在线的节点, 没有配置时返回nil
+func (s *Server) Presence() presence.Presence {
+	return s.options.presence
+}
+
+// InstanceID 服务实例的ID, Presence中记录节点所在的实例
+func (s *Server) InstanceID() string {
+	return s.id
+}
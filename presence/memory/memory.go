@@ -0,0 +1,153 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/wpajqz/linker/presence"
+)
+
+type memoryPresence struct {
+	ttl       time.Duration
+	mutex     sync.RWMutex
+	entries   map[string]presence.Entry
+	watchers  []func(presence.Event)
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPresence 进程内的Presence, 同一个进程中的多个服务实例可以共享, ttl小于等于0时使用一分钟
+func NewPresence(ttl time.Duration) presence.Presence {
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+
+	mp := &memoryPresence{ttl: ttl, entries: make(map[string]presence.Entry), done: make(chan struct{})}
+	go mp.sweep()
+
+	return mp
+}
+
+func (mp *memoryPresence) TTL() time.Duration {
+	return mp.ttl
+}
+
+func (mp *memoryPresence) Touch(entry presence.Entry) error {
+	if entry.LastSeen.IsZero() {
+		entry.LastSeen = time.Now()
+	}
+
+	mp.mutex.Lock()
+	_, ok := mp.entries[entry.NodeID]
+	mp.entries[entry.NodeID] = entry
+	mp.mutex.Unlock()
+
+	if !ok {
+		mp.emit(presence.Event{Type: presence.Online, Entry: entry})
+	}
+
+	return nil
+}
+
+func (mp *memoryPresence) Remove(nodeID string) error {
+	mp.mutex.Lock()
+	entry, ok := mp.entries[nodeID]
+	delete(mp.entries, nodeID)
+	mp.mutex.Unlock()
+
+	if ok {
+		mp.emit(presence.Event{Type: presence.Offline, Entry: entry})
+	}
+
+	return nil
+}
+
+func (mp *memoryPresence) Get(nodeID string) (presence.Entry, bool, error) {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+
+	entry, ok := mp.entries[nodeID]
+	if !ok || entry.Expired(mp.ttl, time.Now()) {
+		return presence.Entry{}, false, nil
+	}
+
+	return entry, true, nil
+}
+
+func (mp *memoryPresence) Tagged(tag string) ([]presence.Entry, error) {
+	return mp.filter(func(e presence.Entry) bool { return e.HasTag(tag) }), nil
+}
+
+func (mp *memoryPresence) Instance(instance string) ([]presence.Entry, error) {
+	return mp.filter(func(e presence.Entry) bool { return e.Instance == instance }), nil
+}
+
+func (mp *memoryPresence) Watch(fn func(presence.Event)) error {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	mp.watchers = append(mp.watchers, fn)
+
+	return nil
+}
+
+func (mp *memoryPresence) Close() error {
+	mp.closeOnce.Do(func() { close(mp.done) })
+
+	return nil
+}
+
+// filter 返回满足条件并且没有过期的节点
+func (mp *memoryPresence) filter(match func(presence.Entry) bool) []presence.Entry {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+
+	now := time.Now()
+
+	var entries []presence.Entry
+	for _, e := range mp.entries {
+		if match(e) && !e.Expired(mp.ttl, now) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+func (mp *memoryPresence) emit(event presence.Event) {
+	mp.mutex.RLock()
+	watchers := mp.watchers
+	mp.mutex.RUnlock()
+
+	for _, fn := range watchers {
+		fn(event)
+	}
+}
+
+// sweep 定期删除超过TTL没有刷新的节点
+func (mp *memoryPresence) sweep() {
+	ticker := time.NewTicker(mp.ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			var expired []presence.Entry
+
+			mp.mutex.Lock()
+			for id, e := range mp.entries {
+				if e.Expired(mp.ttl, now) {
+					expired = append(expired, e)
+					delete(mp.entries, id)
+				}
+			}
+			mp.mutex.Unlock()
+
+			for _, e := range expired {
+				mp.emit(presence.Event{Type: presence.Offline, Entry: e})
+			}
+		case <-mp.done:
+			return
+		}
+	}
+}
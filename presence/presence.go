@@ -0,0 +1,60 @@
+package presence
+
+import "time"
+
+// 节点状态变化的事件类型
+const (
+	Online = iota + 1
+	Offline
+)
+
+type (
+	// Entry 一个在线节点, Instance是持有连接的服务实例
+	Entry struct {
+		NodeID   string    `json:"node_id"`
+		Instance string    `json:"instance"`
+		Tags     []string  `json:"tags,omitempty"`
+		LastSeen time.Time `json:"last_seen"`
+	}
+
+	// Event 节点上线或者下线, 超过TTL没有刷新的节点也会产生下线事件
+	Event struct {
+		Type  int   `json:"type"`
+		Entry Entry `json:"entry"`
+	}
+
+	// Presence 记录集群中所有在线的节点, 服务实例在连接建立, 心跳和关闭时更新,
+	// 并且在TTL内定期刷新本地的连接, 崩溃的实例上的节点超过TTL以后过期
+	Presence interface {
+		TTL() time.Duration
+		// Touch 记录节点在线并刷新最后活跃时间, 节点第一次出现时产生上线事件
+		Touch(entry Entry) error
+		// Remove 节点下线
+		Remove(nodeID string) error
+		// Get 查询节点, 不在线时ok为false
+		Get(nodeID string) (entry Entry, ok bool, err error)
+		// Tagged 查询设置了tag的在线节点, 比如同一个用户的所有设备
+		Tagged(tag string) ([]Entry, error)
+		// Instance 查询服务实例上的在线节点
+		Instance(instance string) ([]Entry, error)
+		// Watch 接收所有服务实例产生的上线和下线事件
+		Watch(fn func(Event)) error
+		Close() error
+	}
+)
+
+// HasTag 节点是否设置了tag
+func (e Entry) HasTag(tag string) bool {
+	for _, t := range e.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expired 最后活跃时间是否已经超过ttl
+func (e Entry) Expired(ttl time.Duration, now time.Time) bool {
+	return now.Sub(e.LastSeen) > ttl
+}
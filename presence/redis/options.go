@@ -0,0 +1,47 @@
+package redis
+
+import "time"
+
+type (
+	Options struct {
+		Address  string
+		Password string
+		DB       int
+		// 节点超过TTL没有刷新时过期
+		TTL time.Duration
+		// 所有key和事件频道的前缀, 不同的集群使用同一个redis时区分开
+		Prefix string
+	}
+
+	Option func(o *Options)
+)
+
+func Address(address string) Option {
+	return func(o *Options) {
+		o.Address = address
+	}
+}
+
+func Password(password string) Option {
+	return func(o *Options) {
+		o.Password = password
+	}
+}
+
+func DB(db int) Option {
+	return func(o *Options) {
+		o.DB = db
+	}
+}
+
+func TTL(ttl time.Duration) Option {
+	return func(o *Options) {
+		o.TTL = ttl
+	}
+}
+
+func Prefix(prefix string) Option {
+	return func(o *Options) {
+		o.Prefix = prefix
+	}
+}
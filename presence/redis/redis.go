@@ -0,0 +1,302 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/wpajqz/linker/presence"
+)
+
+// redisPresence 节点保存在hash中, 有序集合按照最后活跃时间排序用于过期,
+// 每个标签一个集合用于查询. 所有实例都会清理过期的节点, ZREM成功的实例负责发布下线事件
+type redisPresence struct {
+	client    *redis.Client
+	ttl       time.Duration
+	prefix    string
+	mutex     sync.Mutex
+	pubsubs   []*redis.PubSub
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewPresence(opts ...Option) presence.Presence {
+	options := Options{
+		Address: "127.0.0.1:6379",
+		TTL:     time.Minute,
+		Prefix:  "linker:presence:",
+	}
+
+	for _, o := range opts {
+		o(&options)
+	}
+
+	rc := redis.NewClient(&redis.Options{
+		Addr:     options.Address,
+		Password: options.Password,
+		DB:       options.DB,
+	})
+
+	rp := &redisPresence{client: rc, ttl: options.TTL, prefix: options.Prefix, done: make(chan struct{})}
+	go rp.sweep()
+
+	return rp
+}
+
+func (rp *redisPresence) TTL() time.Duration {
+	return rp.ttl
+}
+
+func (rp *redisPresence) Touch(entry presence.Entry) error {
+	if entry.LastSeen.IsZero() {
+		entry.LastSeen = time.Now()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	old, _, err := rp.load(entry.NodeID)
+	if err != nil {
+		return err
+	}
+
+	var added *redis.IntCmd
+	_, err = rp.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(rp.key("entries"), entry.NodeID, data)
+		added = pipe.ZAdd(rp.key("seen"), redis.Z{Score: score(entry.LastSeen), Member: entry.NodeID})
+
+		for _, tag := range old.Tags {
+			if !entry.HasTag(tag) {
+				pipe.SRem(rp.key("tag:"+tag), entry.NodeID)
+			}
+		}
+
+		for _, tag := range entry.Tags {
+			pipe.SAdd(rp.key("tag:"+tag), entry.NodeID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if added.Val() > 0 {
+		return rp.publish(presence.Event{Type: presence.Online, Entry: entry})
+	}
+
+	return nil
+}
+
+func (rp *redisPresence) Remove(nodeID string) error {
+	entry, ok, err := rp.load(nodeID)
+	if err != nil || !ok {
+		return err
+	}
+
+	return rp.remove(entry)
+}
+
+func (rp *redisPresence) Get(nodeID string) (presence.Entry, bool, error) {
+	entry, ok, err := rp.load(nodeID)
+	if err != nil || !ok || entry.Expired(rp.ttl, time.Now()) {
+		return presence.Entry{}, false, err
+	}
+
+	return entry, true, nil
+}
+
+func (rp *redisPresence) Tagged(tag string) ([]presence.Entry, error) {
+	ids, err := rp.client.SMembers(rp.key("tag:" + tag)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return rp.list(ids, func(presence.Entry) bool { return true })
+}
+
+func (rp *redisPresence) Instance(instance string) ([]presence.Entry, error) {
+	ids, err := rp.client.HKeys(rp.key("entries")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return rp.list(ids, func(e presence.Entry) bool { return e.Instance == instance })
+}
+
+func (rp *redisPresence) Watch(fn func(presence.Event)) error {
+	ps := rp.client.Subscribe(rp.key("events"))
+	if _, err := ps.Receive(); err != nil {
+		return err
+	}
+
+	rp.mutex.Lock()
+	rp.pubsubs = append(rp.pubsubs, ps)
+	rp.mutex.Unlock()
+
+	go func() {
+		for msg := range ps.Channel() {
+			var event presence.Event
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				fmt.Printf("presence event error: %s\n", err.Error())
+				continue
+			}
+
+			fn(event)
+		}
+	}()
+
+	return nil
+}
+
+func (rp *redisPresence) Close() error {
+	rp.closeOnce.Do(func() {
+		close(rp.done)
+
+		rp.mutex.Lock()
+		for _, ps := range rp.pubsubs {
+			_ = ps.Close()
+		}
+		rp.mutex.Unlock()
+	})
+
+	return rp.client.Close()
+}
+
+func (rp *redisPresence) key(name string) string {
+	return rp.prefix + name
+}
+
+// load 读取节点, 包括已经过期但是还没有清理的节点
+func (rp *redisPresence) load(nodeID string) (presence.Entry, bool, error) {
+	var entry presence.Entry
+
+	data, err := rp.client.HGet(rp.key("entries"), nodeID).Bytes()
+	if err == redis.Nil {
+		return entry, false, nil
+	}
+
+	if err != nil {
+		return entry, false, err
+	}
+
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, false, err
+	}
+
+	return entry, true, nil
+}
+
+// list 批量读取节点, 过滤掉过期的节点
+func (rp *redisPresence) list(ids []string, match func(presence.Entry) bool) ([]presence.Entry, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	values, err := rp.client.HMGet(rp.key("entries"), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var entries []presence.Entry
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		var e presence.Entry
+		if err := json.Unmarshal([]byte(s), &e); err != nil {
+			continue
+		}
+
+		if match(e) && !e.Expired(rp.ttl, now) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+// remove 删除节点, 只有真正删除了节点的实例发布下线事件
+func (rp *redisPresence) remove(entry presence.Entry) error {
+	var removed *redis.IntCmd
+	_, err := rp.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		removed = pipe.ZRem(rp.key("seen"), entry.NodeID)
+		pipe.HDel(rp.key("entries"), entry.NodeID)
+
+		for _, tag := range entry.Tags {
+			pipe.SRem(rp.key("tag:"+tag), entry.NodeID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if removed.Val() > 0 {
+		return rp.publish(presence.Event{Type: presence.Offline, Entry: entry})
+	}
+
+	return nil
+}
+
+func (rp *redisPresence) publish(event presence.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return rp.client.Publish(rp.key("events"), data).Err()
+}
+
+// sweep 定期清理超过TTL没有刷新的节点, 比如崩溃的实例上的节点
+func (rp *redisPresence) sweep() {
+	ticker := time.NewTicker(rp.ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			ids, err := rp.client.ZRangeByScore(rp.key("seen"), redis.ZRangeBy{
+				Min: "-inf",
+				Max: strconv.FormatFloat(score(now.Add(-rp.ttl)), 'f', 0, 64),
+			}).Result()
+			if err != nil {
+				fmt.Printf("presence sweep error: %s\n", err.Error())
+				continue
+			}
+
+			for _, id := range ids {
+				entry, ok, err := rp.load(id)
+				// 查询以后节点可能已经刷新
+				if err != nil || ok && !entry.Expired(rp.ttl, time.Now()) {
+					continue
+				}
+
+				if !ok {
+					entry = presence.Entry{NodeID: id}
+				}
+
+				if err := rp.remove(entry); err != nil {
+					fmt.Printf("presence sweep error: %s\n", err.Error())
+				}
+			}
+		case <-rp.done:
+			return
+		}
+	}
+}
+
+// score 有序集合中使用毫秒时间戳排序
+func score(t time.Time) float64 {
+	return float64(t.UnixNano() / int64(time.Millisecond))
+}
@@ -0,0 +1,84 @@
+package linker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker/presence"
+	"github.com/wpajqz/linker/presence/memory"
+)
+
+func TestServerPresence(t *testing.T) {
+	p := memory.NewPresence(300 * time.Millisecond)
+	defer p.Close()
+
+	router := linker.NewRouter()
+	router.Route("/login", linker.HandlerFunc(func(ctx linker.Context) {
+		ctx.Connection().Tag("user:1")
+	}))
+
+	events := make(chan presence.Event, 8)
+	s, address, _ := runServer(t, router, linker.Presence(p), linker.WithOnPresence(func(e presence.Event) {
+		events <- e
+	}))
+	defer s.Shutdown(context.Background())
+
+	next := func(typ int) presence.Event {
+		t.Helper()
+
+		select {
+		case e := <-events:
+			if e.Type != typ {
+				t.Fatalf("unexpected event: %+v", e)
+			}
+
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("no presence event")
+		}
+
+		return presence.Event{}
+	}
+
+	c := dial(t, address)
+	if e := next(presence.Online); e.Entry.Instance != s.InstanceID() {
+		t.Errorf("unexpected instance: %s", e.Entry.Instance)
+	}
+
+	if err := c.SyncSend("/login", nil, client.RequestStatusCallback{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 超过TTL以后仍然在线, 服务端会定期刷新本地连接
+	time.Sleep(500 * time.Millisecond)
+
+	entries, err := s.Presence().Tagged("user:1")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected entries: %v, %v", entries, err)
+	}
+
+	// 崩溃的实例不再刷新, 它的节点过期以后下线
+	if err := p.Touch(presence.Entry{NodeID: "ghost", Instance: "crashed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := next(presence.Online); e.Entry.NodeID != "ghost" {
+		t.Errorf("unexpected node: %s", e.Entry.NodeID)
+	}
+
+	if e := next(presence.Offline); e.Entry.NodeID != "ghost" {
+		t.Errorf("unexpected node: %s", e.Entry.NodeID)
+	}
+
+	_ = c.Close()
+	if e := next(presence.Offline); e.Entry.NodeID != entries[0].NodeID {
+		t.Errorf("unexpected node: %s", e.Entry.NodeID)
+	}
+
+	if _, ok, _ := p.Get(entries[0].NodeID); ok {
+		t.Error("node is still online")
+	}
+}
@@ -0,0 +1,137 @@
+package linker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// 服务实例注册的主题前缀, 后面是服务名
+	registryTopic = "linker:registry:"
+	// RegistryInterval 实例定期广播自己的地址, 超过三个周期没有消息的地址被认为已经下线
+	RegistryInterval = 5 * time.Second
+)
+
+// 注册消息的类型
+const (
+	RegistryAnnounce = "announce"
+	RegistryLeave    = "leave"
+	// 客户端开始解析时查询所有实例, 收到的实例立刻广播自己的地址
+	RegistryQuery = "query"
+)
+
+type (
+	// Registration 服务实例通过Broker注册的消息, 客户端的resolver/broker订阅这些消息得到地址
+	Registration struct {
+		Kind     string `json:"kind"`
+		Service  string `json:"service"`
+		Instance string `json:"instance,omitempty"`
+		Address  string `json:"address,omitempty"`
+	}
+
+	// registry 服务启动以后把自己的地址注册到Broker, 关闭时注销
+	registry struct {
+		server  *Server
+		mutex   sync.Mutex
+		address string
+		stopped bool
+		done    chan struct{}
+	}
+)
+
+// RegistryTopic 服务service注册使用的主题
+func RegistryTopic(service string) string {
+	return registryTopic + service
+}
+
+func newRegistry(s *Server) *registry {
+	return &registry{server: s, done: make(chan struct{})}
+}
+
+// start 订阅查询消息并广播自己的地址
+func (r *registry) start(address string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped {
+		return ErrServerClosed
+	}
+
+	r.address = address
+
+	s := r.server
+	if err := s.options.broker.Subscribe(s.id, RegistryTopic(s.options.service), r.receive); err != nil {
+		return err
+	}
+
+	if err := r.publish(RegistryAnnounce); err != nil {
+		return err
+	}
+
+	go r.announce()
+
+	return nil
+}
+
+// stop 通知客户端地址已经下线
+func (r *registry) stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.stopped = true
+	close(r.done)
+
+	if r.address == "" {
+		return
+	}
+
+	if err := r.publish(RegistryLeave); err != nil {
+		fmt.Printf("registry leave error: %s\n", err.Error())
+	}
+
+	_ = r.server.options.broker.UnSubscribe(r.server.id, RegistryTopic(r.server.options.service))
+}
+
+// announce 定期广播自己的地址
+func (r *registry) announce() {
+	ticker := time.NewTicker(RegistryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.publish(RegistryAnnounce); err != nil {
+				fmt.Printf("registry announce error: %s\n", err.Error())
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *registry) receive(data []byte) {
+	var msg Registration
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Kind != RegistryQuery {
+		return
+	}
+
+	if err := r.publish(RegistryAnnounce); err != nil {
+		fmt.Printf("registry announce error: %s\n", err.Error())
+	}
+}
+
+func (r *registry) publish(kind string) error {
+	data, err := json.Marshal(Registration{
+		Kind:     kind,
+		Service:  r.server.options.service,
+		Instance: r.server.id,
+		Address:  r.address,
+	})
+	if err != nil {
+		return err
+	}
+
+	return r.server.options.broker.Publish(RegistryTopic(r.server.options.service), data)
+}
@@ -0,0 +1,142 @@
+package broker
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/broker"
+	"github.com/wpajqz/linker/resolver"
+)
+
+type (
+	// brokerResolver 订阅服务实例通过linker.WithService注册的地址
+	brokerResolver struct {
+		id        string
+		service   string
+		broker    broker.Broker
+		mutex     sync.Mutex
+		instances map[string]instance
+		last      []string
+		update    func(addresses []string)
+		done      chan struct{}
+		closeOnce sync.Once
+	}
+
+	instance struct {
+		address string
+		seen    time.Time
+	}
+)
+
+// NewResolver 发现注册到b的服务service的所有实例, 使用WithService的服务端需要和它使用同一个Broker.
+// 实例下线时立刻移除, 崩溃的实例超过三个linker.RegistryInterval没有消息时移除
+func NewResolver(b broker.Broker, service string) resolver.Resolver {
+	return &brokerResolver{
+		id:        uuid.NewV4().String(),
+		service:   service,
+		broker:    b,
+		instances: make(map[string]instance),
+		done:      make(chan struct{}),
+	}
+}
+
+// Resolve 订阅注册的主题并查询已经启动的实例, 实例的回复异步推送
+func (br *brokerResolver) Resolve(update func(addresses []string)) error {
+	br.mutex.Lock()
+	br.update = update
+	br.mutex.Unlock()
+
+	if err := br.broker.Subscribe(br.id, linker.RegistryTopic(br.service), br.receive); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(linker.Registration{Kind: linker.RegistryQuery, Service: br.service})
+	if err != nil {
+		return err
+	}
+
+	if err := br.broker.Publish(linker.RegistryTopic(br.service), data); err != nil {
+		return err
+	}
+
+	go br.expire()
+
+	return nil
+}
+
+func (br *brokerResolver) receive(data []byte) {
+	var msg linker.Registration
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Instance == "" {
+		return
+	}
+
+	br.mutex.Lock()
+	defer br.mutex.Unlock()
+
+	switch msg.Kind {
+	case linker.RegistryAnnounce:
+		br.instances[msg.Instance] = instance{address: msg.Address, seen: time.Now()}
+	case linker.RegistryLeave:
+		delete(br.instances, msg.Instance)
+	default:
+		return
+	}
+
+	br.push()
+}
+
+// expire 移除长时间没有消息的实例
+func (br *brokerResolver) expire() {
+	ticker := time.NewTicker(linker.RegistryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			br.mutex.Lock()
+			for id, i := range br.instances {
+				if now.Sub(i.seen) > 3*linker.RegistryInterval {
+					delete(br.instances, id)
+				}
+			}
+
+			br.push()
+			br.mutex.Unlock()
+		case <-br.done:
+			return
+		}
+	}
+}
+
+// push 地址变化时推送, 调用时持有mutex, 保证推送的顺序和变化的顺序一致
+func (br *brokerResolver) push() {
+	seen := make(map[string]struct{}, len(br.instances))
+	addresses := make([]string, 0, len(br.instances))
+	for _, i := range br.instances {
+		if _, ok := seen[i.address]; ok {
+			continue
+		}
+
+		seen[i.address] = struct{}{}
+		addresses = append(addresses, i.address)
+	}
+
+	sort.Strings(addresses)
+
+	if resolver.Equal(addresses, br.last) {
+		return
+	}
+
+	br.last = addresses
+	br.update(addresses)
+}
+
+func (br *brokerResolver) Close() error {
+	br.closeOnce.Do(func() { close(br.done) })
+
+	return br.broker.UnSubscribeAll(br.id)
+}
@@ -0,0 +1,55 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wpajqz/linker/resolver"
+)
+
+// 每次查询的超时时间
+const lookupTimeout = 5 * time.Second
+
+// NewResolver 每隔interval查询host的A/AAAA记录, 地址为ip:port
+func NewResolver(host, port string, interval time.Duration) resolver.Resolver {
+	return resolver.NewPoller(interval, func() ([]string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		defer cancel()
+
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		addresses := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			addresses = append(addresses, net.JoinHostPort(ip, port))
+		}
+
+		return addresses, nil
+	})
+}
+
+// NewSRVResolver 每隔interval查询_service._proto.name的SRV记录, 地址为target:port.
+// service和proto都为空时直接查询name
+func NewSRVResolver(service, proto, name string, interval time.Duration) resolver.Resolver {
+	return resolver.NewPoller(interval, func() ([]string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		defer cancel()
+
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, service, proto, name)
+		if err != nil {
+			return nil, err
+		}
+
+		addresses := make([]string, 0, len(records))
+		for _, r := range records {
+			addresses = append(addresses, net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))))
+		}
+
+		return addresses, nil
+	})
+}
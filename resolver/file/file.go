@@ -0,0 +1,47 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wpajqz/linker/resolver"
+	"gopkg.in/yaml.v2"
+)
+
+// config 地址文件的内容
+//
+//	addresses:
+//	  - 10.0.0.1:8080
+//	  - 10.0.0.2:8080
+type config struct {
+	Addresses []string `json:"addresses" yaml:"addresses"`
+}
+
+// NewResolver 每隔interval读取一次path, 地址变化时推送.
+// 扩展名为.yaml或者.yml时按照YAML解析, 其它按照JSON解析
+func NewResolver(path string, interval time.Duration) resolver.Resolver {
+	return resolver.NewPoller(interval, func() ([]string, error) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var c config
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(data, &c)
+		default:
+			err = json.Unmarshal(data, &c)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %s", path, err.Error())
+		}
+
+		return c.Addresses, nil
+	})
+}
@@ -0,0 +1,101 @@
+package resolver
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Resolver 把服务端地址的变化推送给客户端, 每次推送的都是完整的地址列表
+	Resolver interface {
+		// Resolve 开始解析, 能够立刻得到地址的Resolver在返回之前推送第一次结果
+		Resolve(update func(addresses []string)) error
+		Close() error
+	}
+
+	poller struct {
+		interval  time.Duration
+		lookup    func() ([]string, error)
+		done      chan struct{}
+		closeOnce sync.Once
+	}
+)
+
+// NewPoller 每隔interval调用lookup查询地址, 地址变化时才推送, 查询出错时保留上一次的地址.
+// 第一次查询在Resolve中执行, 出错时Resolve返回错误
+func NewPoller(interval time.Duration, lookup func() ([]string, error)) Resolver {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	return &poller{interval: interval, lookup: lookup, done: make(chan struct{})}
+}
+
+func (p *poller) Resolve(update func(addresses []string)) error {
+	last, err := p.resolve()
+	if err != nil {
+		return err
+	}
+
+	update(last)
+
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				addresses, err := p.resolve()
+				if err != nil {
+					fmt.Printf("resolve error: %s\n", err.Error())
+					continue
+				}
+
+				if !Equal(addresses, last) {
+					last = addresses
+					update(addresses)
+				}
+			case <-p.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (p *poller) resolve() ([]string, error) {
+	addresses, err := p.lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	addresses = append([]string(nil), addresses...)
+	sort.Strings(addresses)
+
+	return addresses, nil
+}
+
+func (p *poller) Close() error {
+	p.closeOnce.Do(func() { close(p.done) })
+
+	return nil
+}
+
+// Equal 两个排好序的地址列表是否相同
+func Equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
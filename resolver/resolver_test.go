@@ -0,0 +1,228 @@
+package resolver_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/broker/memory"
+	"github.com/wpajqz/linker/client"
+	brokerresolver "github.com/wpajqz/linker/resolver/broker"
+	fileresolver "github.com/wpajqz/linker/resolver/file"
+)
+
+// fakeResolver 测试中手动推送地址
+type fakeResolver struct {
+	mutex  sync.Mutex
+	update func(addresses []string)
+}
+
+func (r *fakeResolver) Resolve(update func(addresses []string)) error {
+	r.mutex.Lock()
+	r.update = update
+	r.mutex.Unlock()
+
+	return nil
+}
+
+func (r *fakeResolver) push(addresses ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.update(addresses)
+}
+
+func (r *fakeResolver) Close() error {
+	return nil
+}
+
+// runServer 在随机端口上启动服务, 返回监听的地址
+func runServer(t *testing.T, router *linker.Router, opts ...linker.Option) (*linker.Server, string) {
+	t.Helper()
+
+	opts = append([]linker.Option{linker.WithTCPEndpoint(linker.Endpoint{Address: "127.0.0.1:0"})}, opts...)
+	s := linker.NewServer(opts...)
+	s.BindRouter(router)
+
+	go s.Run()
+
+	for i := 0; i < 100; i++ {
+		if addrs := s.Addrs(); len(addrs) > 0 {
+			return s, addrs[0].String()
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("server is not listening")
+	return nil, ""
+}
+
+func whoRouter(name string) *linker.Router {
+	router := linker.NewRouter()
+	router.Route("/who", linker.HandlerFunc(func(ctx linker.Context) {
+		ctx.Success(name)
+	}))
+
+	return router
+}
+
+// endpoints 客户端当前使用的地址, 排好序
+func endpoints(c *client.Client) string {
+	var addresses []string
+	for _, e := range c.Endpoints() {
+		addresses = append(addresses, e.Address())
+	}
+
+	sort.Strings(addresses)
+
+	return strings.Join(addresses, ",")
+}
+
+func waitEndpoints(t *testing.T, c *client.Client, addresses ...string) {
+	t.Helper()
+
+	sort.Strings(addresses)
+	want := strings.Join(addresses, ",")
+
+	for i := 0; i < 100 && endpoints(c) != want; i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if got := endpoints(c); got != want {
+		t.Fatalf("unexpected endpoints: %s, want %s", got, want)
+	}
+}
+
+func TestClientResolver(t *testing.T) {
+	s1, a1 := runServer(t, whoRouter("s1"))
+	defer s1.Shutdown(context.Background())
+
+	s2, a2 := runServer(t, whoRouter("s2"))
+	defer s2.Shutdown(context.Background())
+
+	r := &fakeResolver{}
+	c, err := client.NewClient(nil, client.InitialCapacity(1), client.WithResolver(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	who := func() string {
+		var v string
+		if err := c.Call(context.Background(), "/who", nil, &v); err != nil {
+			t.Fatal(err)
+		}
+
+		return v
+	}
+
+	if err := c.Call(context.Background(), "/who", nil, nil); err != client.ErrNoAvailableEndpoint {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r.push(a1)
+	if v := who(); v != "s1" {
+		t.Errorf("unexpected reply: %s", v)
+	}
+
+	r.push(a1, a2, a2)
+	waitEndpoints(t, c, a1, a2)
+
+	counts := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		counts[who()]++
+	}
+
+	if counts["s1"] != 2 || counts["s2"] != 2 {
+		t.Errorf("unexpected replies: %v", counts)
+	}
+
+	r.push(a2)
+	waitEndpoints(t, c, a2)
+
+	for i := 0; i < 3; i++ {
+		if v := who(); v != "s2" {
+			t.Errorf("removed address still used: %s", v)
+		}
+	}
+}
+
+func TestBrokerResolver(t *testing.T) {
+	b := memory.NewBroker()
+
+	s1, a1 := runServer(t, whoRouter("s1"), linker.Broker(b), linker.WithService("who"))
+	defer s1.Shutdown(context.Background())
+
+	c, err := client.NewClient(nil, client.InitialCapacity(1), client.WithResolver(brokerresolver.NewResolver(b, "who")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	waitEndpoints(t, c, a1)
+
+	s2, a2 := runServer(t, whoRouter("s2"), linker.Broker(b), linker.WithService("who"))
+	waitEndpoints(t, c, a1, a2)
+
+	// 服务关闭时注销地址
+	if err := s2.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	waitEndpoints(t, c, a1)
+
+	var v string
+	if err := c.Call(context.Background(), "/who", nil, &v); err != nil || v != "s1" {
+		t.Errorf("unexpected reply: %s, %v", v, err)
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "linker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s1, a1 := runServer(t, whoRouter("s1"))
+	defer s1.Shutdown(context.Background())
+
+	s2, a2 := runServer(t, whoRouter("s2"))
+	defer s2.Shutdown(context.Background())
+
+	path := filepath.Join(dir, "servers.yaml")
+	write := func(addresses ...string) {
+		data := "addresses:\n"
+		for _, a := range addresses {
+			data += "  - " + a + "\n"
+		}
+
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(a1)
+
+	c, err := client.NewClient(nil, client.InitialCapacity(1), client.WithResolver(fileresolver.NewResolver(path, 20*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	waitEndpoints(t, c, a1)
+
+	write(a1, a2)
+	waitEndpoints(t, c, a1, a2)
+
+	write(a2)
+	waitEndpoints(t, c, a2)
+}
@@ -0,0 +1,22 @@
+package static
+
+import "github.com/wpajqz/linker/resolver"
+
+type staticResolver struct {
+	addresses []string
+}
+
+// NewResolver 固定的地址列表, Resolve时推送一次
+func NewResolver(addresses ...string) resolver.Resolver {
+	return &staticResolver{addresses: append([]string(nil), addresses...)}
+}
+
+func (sr *staticResolver) Resolve(update func(addresses []string)) error {
+	update(append([]string(nil), sr.addresses...))
+
+	return nil
+}
+
+func (sr *staticResolver) Close() error {
+	return nil
+}
@@ -0,0 +1,265 @@
+package linker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/wpajqz/linker/broker"
+)
+
+// 房间的广播通过该前缀的主题在服务实例之间传递
+const roomTopicPrefix = "linker:room:"
+
+type (
+	// RoomHandler 连接加入或者离开房间时的回调
+	RoomHandler func(room string, c *Connection)
+
+	// Rooms 房间的成员关系. 每个服务实例只记录本地连接的成员关系,
+	// 本地有成员的房间订阅Broker上对应的主题, 广播通过Broker发送到所有实例
+	Rooms struct {
+		id      string
+		broker  broker.Broker
+		onJoin  RoomHandler
+		onLeave RoomHandler
+		mutex   sync.RWMutex
+		rooms   map[string]map[string]*Connection
+		joined  map[string]map[string]struct{}
+		// 串行化房间主题的订阅和取消订阅, 调用Broker时不持有mutex, 不影响广播的推送
+		subscription sync.Mutex
+	}
+
+	// roomMessage 在服务实例之间传递的广播消息
+	roomMessage struct {
+		Operator string   `json:"operator"`
+		Body     []byte   `json:"body"`
+		Exclude  []string `json:"exclude,omitempty"`
+	}
+)
+
+func newRooms(id string, options Options) *Rooms {
+	return &Rooms{
+		id:      id,
+		broker:  options.broker,
+		onJoin:  options.joinHandler,
+		onLeave: options.leaveHandler,
+		rooms:   make(map[string]map[string]*Connection),
+		joined:  make(map[string]map[string]struct{}),
+	}
+}
+
+// Join 连接加入房间, 已经是成员时不做处理
+func (r *Rooms) Join(room string, c *Connection) error {
+	r.subscription.Lock()
+
+	r.mutex.RLock()
+	_, ok := r.rooms[room]
+	r.mutex.RUnlock()
+
+	// 本地的第一个成员加入时订阅房间的广播
+	if !ok {
+		if err := r.broker.Subscribe(r.id, roomTopicPrefix+room, func(data []byte) {
+			r.deliver(room, data)
+		}); err != nil {
+			r.subscription.Unlock()
+			return err
+		}
+	}
+
+	r.mutex.Lock()
+	members, ok := r.rooms[room]
+	if !ok {
+		members = make(map[string]*Connection)
+		r.rooms[room] = members
+	}
+
+	_, member := members[c.id]
+	if !member {
+		members[c.id] = c
+		if r.joined[c.id] == nil {
+			r.joined[c.id] = make(map[string]struct{})
+		}
+		r.joined[c.id][room] = struct{}{}
+	}
+	r.mutex.Unlock()
+
+	r.subscription.Unlock()
+
+	if !member && r.onJoin != nil {
+		r.onJoin(room, c)
+	}
+
+	return nil
+}
+
+// Leave 连接离开房间, 不是成员时不做处理
+func (r *Rooms) Leave(room string, c *Connection) error {
+	r.subscription.Lock()
+
+	r.mutex.Lock()
+	left, empty := r.leave(room, c)
+	r.mutex.Unlock()
+
+	// 本地的最后一个成员离开时取消订阅
+	var err error
+	if empty {
+		err = r.broker.UnSubscribe(r.id, roomTopicPrefix+room)
+	}
+
+	r.subscription.Unlock()
+
+	if left && r.onLeave != nil {
+		r.onLeave(room, c)
+	}
+
+	return err
+}
+
+// leaveAll 连接关闭时离开所有房间
+func (r *Rooms) leaveAll(c *Connection) {
+	for _, room := range r.Joined(c.id) {
+		if err := r.Leave(room, c); err != nil {
+			fmt.Printf("leave room error: %s\n", err.Error())
+		}
+	}
+}
+
+// leave 移除成员, empty表示房间在本地已经没有成员, 调用时持有mutex
+func (r *Rooms) leave(room string, c *Connection) (left, empty bool) {
+	members, ok := r.rooms[room]
+	if !ok {
+		return false, false
+	}
+
+	if _, ok := members[c.id]; !ok {
+		return false, false
+	}
+
+	delete(members, c.id)
+	delete(r.joined[c.id], room)
+	if len(r.joined[c.id]) == 0 {
+		delete(r.joined, c.id)
+	}
+
+	if len(members) > 0 {
+		return true, false
+	}
+
+	delete(r.rooms, room)
+
+	return true, true
+}
+
+// Members 房间在当前实例上的成员. 成员关系不在实例之间同步, 结果不包括其它实例上的连接,
+// 需要整个集群的成员时由应用在共享存储中记录
+func (r *Rooms) Members(room string) []*Connection {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	conns := make([]*Connection, 0, len(r.rooms[room]))
+	for _, c := range r.rooms[room] {
+		conns = append(conns, c)
+	}
+
+	return conns
+}
+
+// Has 节点是否是房间在当前实例上的成员, 连接在其它实例上时返回false
+func (r *Rooms) Has(room, nodeID string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	_, ok := r.rooms[room][nodeID]
+
+	return ok
+}
+
+// Joined 节点加入的所有房间, 只能查询连接在当前实例上的节点
+func (r *Rooms) Joined(nodeID string) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	rooms := make([]string, 0, len(r.joined[nodeID]))
+	for room := range r.joined[nodeID] {
+		rooms = append(rooms, room)
+	}
+
+	sort.Strings(rooms)
+
+	return rooms
+}
+
+// List 当前实例上有成员的所有房间, 只在其它实例上有成员的房间不会出现
+func (r *Rooms) List() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	rooms := make([]string, 0, len(r.rooms))
+	for room := range r.rooms {
+		rooms = append(rooms, room)
+	}
+
+	sort.Strings(rooms)
+
+	return rooms
+}
+
+// Broadcast 向房间所有实例上的成员推送消息, exclude中的节点不会收到
+func (r *Rooms) Broadcast(room, operator string, body []byte, exclude ...string) error {
+	data, err := json.Marshal(roomMessage{Operator: operator, Body: body, Exclude: exclude})
+	if err != nil {
+		return err
+	}
+
+	return r.broker.Publish(roomTopicPrefix+room, data)
+}
+
+// deliver 把Broker上收到的广播推送给本地成员
+func (r *Rooms) deliver(room string, data []byte) {
+	var msg roomMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		fmt.Printf("room message error: %s\n", err.Error())
+		return
+	}
+
+	excluded := make(map[string]struct{}, len(msg.Exclude))
+	for _, id := range msg.Exclude {
+		excluded[id] = struct{}{}
+	}
+
+	for _, c := range r.Members(room) {
+		if _, ok := excluded[c.id]; ok {
+			continue
+		}
+
+		if _, err := c.Write(msg.Operator, msg.Body); err != nil {
+			fmt.Printf("write message error: %s\n", err.Error())
+		}
+	}
+}
+
+// Join 加入房间
+func (c *Connection) Join(room string) error {
+	return c.rooms.Join(room, c)
+}
+
+// Leave 离开房间
+func (c *Connection) Leave(room string) error {
+	return c.rooms.Leave(room, c)
+}
+
+// Rooms 连接加入的所有房间
+func (c *Connection) Rooms() []string {
+	return c.rooms.Joined(c.id)
+}
+
+// Broadcast 向房间的其它成员推送消息, 不包括自己
+func (c *Connection) Broadcast(room, operator string, body []byte) error {
+	return c.rooms.Broadcast(room, operator, body, c.id)
+}
+
+// Rooms 服务端的房间. 广播会发送到所有实例, 成员关系的查询只包括当前实例上的连接
+func (s *Server) Rooms() *Rooms {
+	return s.rooms
+}
@@ -0,0 +1,117 @@
+package linker_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/broker/memory"
+	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker/client/export"
+)
+
+func TestServerRooms(t *testing.T) {
+	// 路由只能绑定到一个服务
+	newRouter := func() *linker.Router {
+		router := linker.NewRouter()
+		router.Route("/join", linker.HandlerFunc(func(ctx linker.Context) {
+			if err := ctx.Connection().Join("lobby"); err != nil {
+				ctx.Error(linker.StatusInternalServerError, err.Error())
+			}
+		}))
+		router.Route("/say", linker.HandlerFunc(func(ctx linker.Context) {
+			if err := ctx.Connection().Broadcast("lobby", "chat", ctx.RawBody()); err != nil {
+				ctx.Error(linker.StatusInternalServerError, err.Error())
+			}
+		}))
+
+		return router
+	}
+
+	events := make(chan string, 16)
+	b := memory.NewBroker()
+	opts := []linker.Option{
+		linker.Broker(b),
+		linker.WithOnJoin(func(room string, c *linker.Connection) { events <- "join:" + room }),
+		linker.WithOnLeave(func(room string, c *linker.Connection) { events <- "leave:" + room }),
+	}
+
+	// 两个服务实例共享同一个Broker, 广播可以到达另一个实例上的成员
+	s1, address1, _ := runServer(t, newRouter(), opts...)
+	defer s1.Shutdown(context.Background())
+
+	s2, address2, _ := runServer(t, newRouter(), opts...)
+	defer s2.Shutdown(context.Background())
+
+	received := make(chan string, 8)
+	join := func(name, address string) *export.Client {
+		c := dial(t, address)
+		if err := c.AddMessageListener("chat", export.HandlerFunc(func(header, body []byte) {
+			received <- name + ":" + string(body)
+		})); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.SyncSend("/join", nil, client.RequestStatusCallback{}); err != nil {
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	alice := join("alice", address1)
+	bob := join("bob", address1)
+	_ = join("carol", address2)
+
+	for i := 0; i < 3; i++ {
+		if v := <-events; v != "join:lobby" {
+			t.Errorf("unexpected event: %s", v)
+		}
+	}
+
+	if n := len(s1.Rooms().Members("lobby")); n != 2 {
+		t.Errorf("unexpected members: %d", n)
+	}
+
+	if err := alice.SyncSend("/say", "hi", client.RequestStatusCallback{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		select {
+		case v := <-received:
+			got = append(got, v)
+		case <-time.After(time.Second):
+			t.Fatal("no message")
+		}
+	}
+
+	sort.Strings(got)
+	if got[0] != `bob:"hi"` || got[1] != `carol:"hi"` {
+		t.Errorf("unexpected messages: %v", got)
+	}
+
+	select {
+	case v := <-received:
+		t.Errorf("sender received its own message: %s", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_ = bob.Close()
+
+	select {
+	case v := <-events:
+		if v != "leave:lobby" {
+			t.Errorf("unexpected event: %s", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no leave event")
+	}
+
+	if n := len(s1.Rooms().Members("lobby")); n != 1 {
+		t.Errorf("unexpected members: %d", n)
+	}
+}
@@ -1,68 +1,289 @@
 package linker
 
 import (
+	"fmt"
 	"hash/crc32"
+	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
 )
 
 type (
 	Router struct {
-		prefix           string
-		handlerContainer map[uint32]Handler
-		routerMiddleware map[uint32][]Middleware
-		middleware       []Middleware
+		prefix     string
+		parent     *Router
+		table      *routeTable
+		middleware []Middleware
+		versions   []string
 	}
 
 	LinkRouter func(*Router)
+
+	// OperatorTable 显式指定路由的operator, 需要跨语言保持稳定的数字ID时使用, 客户端使用同一份配置
+	OperatorTable map[string]uint32
+
+	// RouteInfo 路由表中的一条路由
+	RouteInfo struct {
+		Pattern    string
+		Operator   uint32
+		Handler    string
+		Middleware []string
+	}
+
+	// routeTable 同一个路由树中所有分组共享的路由表
+	routeTable struct {
+		operators                  OperatorTable
+		routes                     map[uint32]*route
+		notFound, methodNotAllowed Handler
+		// 包装了全局中间件的NotFound和MethodNotAllowed
+		notFoundChain, methodNotAllowedChain Handler
+	}
+
+	route struct {
+		pattern    string
+		handler    Handler
+		middleware []Middleware
+		group      *Router
+		// 包装了所有中间件的处理器, 注册路由或者中间件时组合
+		composed Handler
+	}
 )
 
 func NewRouter() *Router {
+	r := &Router{
+		table: &routeTable{
+			routes: make(map[uint32]*route),
+			notFound: HandlerFunc(func(ctx Context) {
+				ctx.Error(StatusNotFound, StatusText(StatusNotFound))
+			}),
+			methodNotAllowed: HandlerFunc(func(ctx Context) {
+				ctx.Error(StatusMethodNotAllowed, "version "+strconv.Quote(ctx.Version())+" is not allowed")
+			}),
+		},
+	}
+	r.compose()
+
+	return r
+}
+
+// Group 创建路由分组, 分组的前缀和中间件会叠加到父级路由上, 分组可以继续嵌套
+func (r *Router) Group(prefix string, middleware ...Middleware) *Router {
 	return &Router{
-		handlerContainer: make(map[uint32]Handler),
-		routerMiddleware: make(map[uint32][]Middleware),
+		prefix:     r.prefix + prefix,
+		parent:     r,
+		table:      r.table,
+		middleware: middleware,
 	}
 }
 
+// Operators 开启显式operator模式, 之后注册的路由必须在table中指定operator, 不再使用crc32计算
+func (r *Router) Operators(table OperatorTable) *Router {
+	r.table.operators = table
+
+	return r
+}
+
+// NotFound 设置请求的operator没有注册时的处理器, 默认回复StatusNotFound
+func (r *Router) NotFound(handler Handler) *Router {
+	r.table.notFound = handler
+	r.compose()
+
+	return r
+}
+
+// MethodNotAllowed 设置客户端版本不被路由接受时的处理器, 默认回复StatusMethodNotAllowed
+func (r *Router) MethodNotAllowed(handler Handler) *Router {
+	r.table.methodNotAllowed = handler
+	r.compose()
+
+	return r
+}
+
+// Versions 限制该路由及其分组只接受指定版本的客户端请求, 子分组可以重新设置
+func (r *Router) Versions(versions ...string) *Router {
+	r.versions = versions
+
+	return r
+}
+
 // 获取带命名空间router
 func (r *Router) NSRouter(prefix string, params ...LinkRouter) *Router {
-	r.prefix = prefix
+	g := r.Group(prefix)
 	for _, p := range params {
-		p(r)
+		p(g)
 	}
 
-	return r
+	return g
 }
 
 // 命名空间路由注册路由和中间件
 func (r *Router) NSRoute(pattern string, handler Handler, middleware ...Middleware) LinkRouter {
 	return func(r *Router) {
-		if r.prefix != "" {
-			pattern = r.prefix + pattern
-		}
-
 		r.Route(pattern, handler, middleware...)
 	}
 }
 
-// 注册路由，路由中间件
+// 注册路由，路由中间件, operator冲突或者重复注册时panic
 func (r *Router) Route(pattern string, handler Handler, middleware ...Middleware) *Router {
-	operator := crc32.ChecksumIEEE([]byte(pattern))
+	pattern = r.prefix + pattern
+
+	var operator uint32
+	if r.table.operators != nil {
+		v, ok := r.table.operators[pattern]
+		if !ok {
+			panic("Unavailable operator, pattern " + strconv.Quote(pattern) + " is not in the operator table")
+		}
+
+		operator = v
+	} else {
+		operator = crc32.ChecksumIEEE([]byte(pattern))
+	}
+
 	if operator <= OperatorMax {
-		panic("Unavailable operator, the value of crc32 need less than " + strconv.Itoa(OperatorMax))
+		panic("Unavailable operator, the value of operator need greater than " + strconv.Itoa(OperatorMax))
 	}
 
-	r.routerMiddleware[operator] = append(r.routerMiddleware[operator], middleware...)
+	r.handle(operator, pattern, handler, middleware...)
+
+	return r
+}
 
-	if _, ok := r.handlerContainer[operator]; !ok {
-		r.handlerContainer[operator] = handler
+// handle 把处理器注册到路由表中
+func (r *Router) handle(operator uint32, pattern string, handler Handler, middleware ...Middleware) {
+	if handler == nil {
+		panic("Unavailable handler, the handler of " + strconv.Quote(pattern) + " is nil")
 	}
 
-	return r
+	if v, ok := r.table.routes[operator]; ok {
+		if v.pattern == pattern {
+			panic("Duplicate route, " + strconv.Quote(pattern) + " is already registered")
+		}
+
+		panic(fmt.Sprintf("Operator collision, %q and %q both map to operator %d", v.pattern, pattern, operator))
+	}
+
+	rt := &route{pattern: pattern, handler: handler, middleware: middleware, group: r}
+	rt.composed = chain(rt.handler, rt.chain()...)
+	r.table.routes[operator] = rt
 }
 
-// 添加请求需要进行处理的中间件
+// lookup 获取处理请求的处理器, 没有注册的operator交给NotFound, 版本不匹配的交给MethodNotAllowed,
+// 这两种情况只执行全局中间件
+func (r *Router) lookup(ctx Context, operator uint32) Handler {
+	rt, ok := r.table.routes[operator]
+	if !ok {
+		return r.table.notFoundChain
+	}
+
+	if versions := rt.group.acceptVersions(); len(versions) > 0 {
+		allowed := false
+		for _, v := range versions {
+			if v == ctx.Version() {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return r.table.methodNotAllowedChain
+		}
+	}
+
+	return rt.composed
+}
+
+// compose 重新组合所有处理器的中间件链, 之后处理请求时直接使用组合好的处理器
+func (r *Router) compose() {
+	t, global := r.table, r.global()
+	t.notFoundChain = chain(t.notFound, global...)
+	t.methodNotAllowedChain = chain(t.methodNotAllowed, global...)
+
+	for _, rt := range t.routes {
+		rt.composed = chain(rt.handler, rt.chain()...)
+	}
+}
+
+// global 返回根路由上的全局中间件
+func (r *Router) global() []Middleware {
+	root := r
+	for root.parent != nil {
+		root = root.parent
+	}
+
+	return root.middleware
+}
+
+// acceptVersions 返回最近的分组上设置的版本限制
+func (r *Router) acceptVersions() []string {
+	for g := r; g != nil; g = g.parent {
+		if g.versions != nil {
+			return g.versions
+		}
+	}
+
+	return nil
+}
+
+// Routes 返回注册的路由表, 按照pattern排序
+func (r *Router) Routes() []RouteInfo {
+	routes := make([]RouteInfo, 0, len(r.table.routes))
+	for operator, rt := range r.table.routes {
+		info := RouteInfo{Pattern: rt.pattern, Operator: operator, Handler: nameOf(rt.handler)}
+		for _, m := range rt.chain() {
+			info.Middleware = append(info.Middleware, nameOf(m))
+		}
+
+		routes = append(routes, info)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Pattern < routes[j].Pattern
+	})
+
+	return routes
+}
+
+// 添加请求需要进行处理的中间件, 在分组上调用时只作用于分组内的路由
 func (r *Router) Use(middleware ...Middleware) *Router {
 	r.middleware = append(r.middleware, middleware...)
+	r.compose()
 
 	return r
 }
+
+// chain 按照全局, 分组, 路由的顺序返回作用于该路由的中间件
+func (rt *route) chain() []Middleware {
+	var groups []*Router
+	for g := rt.group; g != nil; g = g.parent {
+		groups = append(groups, g)
+	}
+
+	var middleware []Middleware
+	for i := len(groups) - 1; i >= 0; i-- {
+		middleware = append(middleware, groups[i].middleware...)
+	}
+
+	return append(middleware, rt.middleware...)
+}
+
+// Operator 根据operator table获取pattern对应的operator, 不存在时使用crc32计算
+func (t OperatorTable) Operator(pattern string) uint32 {
+	if v, ok := t[pattern]; ok {
+		return v
+	}
+
+	return crc32.ChecksumIEEE([]byte(pattern))
+}
+
+// nameOf 获取处理器或中间件的名称
+func nameOf(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Func {
+		if fn := runtime.FuncForPC(rv.Pointer()); fn != nil {
+			return fn.Name()
+		}
+	}
+
+	return fmt.Sprintf("%T", v)
+}
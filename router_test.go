@@ -0,0 +1,118 @@
+package linker
+
+import (
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestRouterGroup(t *testing.T) {
+	var trace []string
+
+	mark := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx Context) {
+				trace = append(trace, name)
+				next.Handle(ctx)
+			})
+		}
+	}
+
+	r := NewRouter().Use(mark("global"))
+	v1 := r.Group("/v1", mark("v1"))
+	admin := v1.Group("/admin", mark("admin"))
+	admin.Route("/users", HandlerFunc(func(ctx Context) { trace = append(trace, "handler") }), mark("route"))
+	r.Group("/v2").Route("/users", HandlerFunc(func(ctx Context) { trace = append(trace, "v2") }))
+
+	r.lookup(nil, crc32.ChecksumIEEE([]byte("/v1/admin/users"))).Handle(nil)
+	if got := strings.Join(trace, ","); got != "global,v1,admin,route,handler" {
+		t.Errorf("unexpected middleware order: %s", got)
+	}
+
+	trace = nil
+	r.lookup(nil, crc32.ChecksumIEEE([]byte("/v2/users"))).Handle(nil)
+	if got := strings.Join(trace, ","); got != "global,v2" {
+		t.Errorf("group middleware leaked into sibling group: %s", got)
+	}
+
+	trace = nil
+	r.NotFound(HandlerFunc(func(ctx Context) { trace = append(trace, "not found") }))
+	r.lookup(nil, crc32.ChecksumIEEE([]byte("/v3/users"))).Handle(nil)
+	if got := strings.Join(trace, ","); got != "global,not found" {
+		t.Errorf("unexpected not found chain: %s", got)
+	}
+}
+
+func TestRouterComposeOnce(t *testing.T) {
+	var wrapped, called int
+
+	count := func(next Handler) Handler {
+		wrapped++
+		return HandlerFunc(func(ctx Context) {
+			called++
+			next.Handle(ctx)
+		})
+	}
+
+	r := NewRouter()
+	r.Route("/users", HandlerFunc(func(ctx Context) {}))
+
+	// 注册路由以后添加的中间件同样生效
+	r.Use(count)
+	operator := crc32.ChecksumIEEE([]byte("/users"))
+	n := wrapped
+
+	for i := 0; i < 3; i++ {
+		r.lookup(nil, operator).Handle(nil)
+	}
+
+	if called != 3 {
+		t.Errorf("middleware called %d times, want 3", called)
+	}
+
+	if wrapped != n {
+		t.Errorf("chain rebuilt per request: %d wraps after %d", wrapped, n)
+	}
+}
+
+func TestRouterOperatorCollision(t *testing.T) {
+	r := NewRouter()
+	r.Route("/plumless", HandlerFunc(func(ctx Context) {}))
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for colliding operator")
+		}
+	}()
+
+	// "/plumless"和"/buckeroo"的crc32相同
+	r.Route("/buckeroo", HandlerFunc(func(ctx Context) {}))
+}
+
+func TestRouterOperatorTable(t *testing.T) {
+	r := NewRouter().Operators(OperatorTable{"/v1/users": 2000, "/v1/orders": 2001})
+	v1 := r.Group("/v1")
+	v1.Route("/users", HandlerFunc(func(ctx Context) {}))
+	v1.Route("/orders", HandlerFunc(func(ctx Context) {}))
+
+	routes := r.Routes()
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(routes))
+	}
+
+	if routes[0].Pattern != "/v1/orders" || routes[0].Operator != 2001 {
+		t.Errorf("unexpected route: %+v", routes[0])
+	}
+
+	if routes[1].Pattern != "/v1/users" || routes[1].Operator != 2000 {
+		t.Errorf("unexpected route: %+v", routes[1])
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for pattern missing in operator table")
+		}
+	}()
+
+	v1.Route("/missing", HandlerFunc(func(ctx Context) {}))
+}
@@ -1,6 +1,15 @@
 package linker
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
 	"github.com/wpajqz/linker/broker/memory"
 	"github.com/wpajqz/linker/codec"
 	"golang.org/x/sync/errgroup"
@@ -10,19 +19,31 @@ const (
 	OperatorHeartbeat = iota
 	OperatorRegisterListener
 	OperatorRemoveListener
+	OperatorHandshake
 	OperatorMax = 1024
 )
 
 const (
-	NetworkTCP = "tcp"
-	NetworkUDP = "udp"
+	NetworkTCP  = "tcp"
+	NetworkUDP  = "udp"
+	NetworkUnix = "unix"
 )
 
 const (
 	errorTag = "error"
 	nodeID   = "node_id"
+	// 连接级别的context中保存*Connection
+	connectionKey = "connection"
+	// 握手时客户端通过该属性传递支持的最高协议版本, 服务端回复协商的版本
+	protocolProperty = "protocol"
 )
 
+// forceCloseTimeout 强制关闭连接以后等待连接清理的时间
+const forceCloseTimeout = time.Second
+
+// ErrServerClosed 服务调用Shutdown以后, Run返回的错误
+var ErrServerClosed = errors.New("linker: server closed")
+
 type (
 	Handler interface {
 		Handle(Context)
@@ -31,8 +52,26 @@ type (
 	HandlerFunc func(Context)
 
 	Server struct {
-		options Options
-		router  *Router
+		id          string
+		options     Options
+		router      *Router
+		mutex       sync.Mutex
+		inShutdown  bool
+		forced      bool
+		transports  map[Transport]struct{}
+		conns       map[Conn]context.CancelFunc
+		connections *Connections
+		rooms       *Rooms
+		tracker     *tracker
+		cluster     *cluster
+		registry    *registry
+		done        chan struct{}
+		wg          sync.WaitGroup
+	}
+
+	// shutdowner 可以优雅关闭的传输层, 比如需要等待普通http请求完成的websocket传输层
+	shutdowner interface {
+		Shutdown(ctx context.Context) error
 	}
 )
 
@@ -49,33 +88,339 @@ func NewServer(opts ...Option) *Server {
 		o(&options)
 	}
 
-	return &Server{options: options}
+	id := uuid.NewV4().String()
+
+	s := &Server{
+		id:          id,
+		options:     options,
+		rooms:       newRooms(id, options),
+		tracker:     &tracker{presence: options.presence, instance: id},
+		done:        make(chan struct{}),
+		transports:  make(map[Transport]struct{}),
+		conns:       make(map[Conn]context.CancelFunc),
+		connections: newConnections(),
+	}
+
+	if options.cluster {
+		s.cluster = newCluster(s)
+	}
+
+	if options.service != "" {
+		s.registry = newRegistry(s)
+	}
+
+	return s
 }
 
 func (s *Server) Run() error {
-	var eg errgroup.Group
+	var (
+		transports []Transport
+		tcp        Transport
+	)
 
 	if s.options.tcpEndpoint != nil {
-		eg.Go(func() error {
-			return s.runTCP(s.options.tcpEndpoint.Address)
-		})
+		tcp = newTCPTransport(s.options.tcpEndpoint.Address, s.options.tcpEndpoint.TLS, s.options)
+		transports = append(transports, tcp)
 	}
 
 	if s.options.httpEndpoint != nil {
-		eg.Go(func() error {
-			return s.runHTTP(s.options.httpEndpoint.Address, s.options.httpEndpoint.WSRoute, s.options.httpEndpoint.Handler)
-		})
+		transports = append(transports, newWebSocketTransport(s.options.httpEndpoint, s.options))
 	}
 
 	if s.options.udpEndpoint != nil {
+		transports = append(transports, newUDPTransport(s.options.udpEndpoint.Address, s.options))
+	}
+
+	if s.options.unixEndpoint != nil {
+		transports = append(transports, newUnixTransport(s.options.unixEndpoint.Address, s.options.unixPermission, s.options))
+	}
+
+	transports = append(transports, s.options.transports...)
+
+	for _, t := range transports {
+		if err := t.Listen(); err != nil {
+			_ = s.Shutdown(context.Background())
+			return err
+		}
+
+		if !s.trackTransport(t) {
+			_ = t.Close()
+			return ErrServerClosed
+		}
+	}
+
+	if s.options.api != nil && s.options.tcpEndpoint != nil {
+		if err := s.options.api.Dial(NetworkTCP, s.options.tcpEndpoint.Address); err != nil {
+			_ = s.Shutdown(context.Background())
+			return err
+		}
+	}
+
+	if s.options.presence != nil {
+		if s.options.presenceHandler != nil {
+			if err := s.options.presence.Watch(s.options.presenceHandler); err != nil {
+				_ = s.Shutdown(context.Background())
+				return err
+			}
+		}
+
+		go s.tracker.refresh(s.connections, s.done)
+	}
+
+	if s.cluster != nil {
+		if err := s.cluster.start(); err != nil {
+			_ = s.Shutdown(context.Background())
+			return err
+		}
+	}
+
+	if s.registry != nil {
+		address := s.options.advertise
+		if address == "" && tcp != nil {
+			address = tcp.Addr().String()
+
+			// 监听0.0.0.0或者[::]时其它机器无法通过这个地址访问
+			if addr, ok := tcp.Addr().(*net.TCPAddr); ok && addr.IP.IsUnspecified() {
+				_ = s.Shutdown(context.Background())
+				return fmt.Errorf("linker: tcp endpoint %s is not routable, set an advertise address", address)
+			}
+		}
+
+		if address == "" {
+			_ = s.Shutdown(context.Background())
+			return errors.New("linker: service registry requires a tcp endpoint or an advertise address")
+		}
+
+		if err := s.registry.start(address); err != nil {
+			_ = s.Shutdown(context.Background())
+			return err
+		}
+	}
+
+	var eg errgroup.Group
+	for _, t := range transports {
+		t := t
 		eg.Go(func() error {
-			return s.runUDP(s.options.udpEndpoint.Address)
+			return t.Serve(s.serveConn)
 		})
 	}
 
 	return eg.Wait()
 }
 
+// Shutdown 优雅关闭服务: 停止接收新连接, 等待正在处理的请求完成, 执行连接关闭的回调后返回.
+// ctx到期时强制关闭剩余的连接并返回ctx.Err()
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	first := !s.inShutdown
+	if first {
+		close(s.done)
+	}
+	s.inShutdown = true
+
+	transports := make([]Transport, 0, len(s.transports))
+	for t := range s.transports {
+		transports = append(transports, t)
+	}
+
+	// 中断连接上阻塞的读取, 让读循环退出并等待正在处理的请求
+	for c := range s.conns {
+		_ = c.SetReadDeadline(time.Now())
+	}
+	s.mutex.Unlock()
+
+	if first && s.registry != nil {
+		s.registry.stop()
+	}
+
+	var err error
+	for _, t := range transports {
+		var e error
+		if sd, ok := t.(shutdowner); ok {
+			e = sd.Shutdown(ctx)
+		} else {
+			e = t.Close()
+		}
+
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	// 正在处理的请求可能还需要转发到其它实例, 连接全部结束以后才离开集群
+	if first && s.cluster != nil {
+		defer s.cluster.stop()
+	}
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		// 取消剩余连接上的请求, 等待请求的handler不会一直阻塞连接的清理
+		s.mutex.Lock()
+		s.forced = true
+		for c, cancel := range s.conns {
+			if cancel != nil {
+				cancel()
+			}
+
+			_ = c.Close()
+		}
+		s.mutex.Unlock()
+
+		// 给连接一点时间执行清理, 避免返回以后OnClose等回调仍在运行
+		select {
+		case <-done:
+		case <-time.After(forceCloseTimeout):
+		}
+
+		return ctx.Err()
+	}
+}
+
+// Addrs 返回所有传输层监听的地址
+func (s *Server) Addrs() []net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	addrs := make([]net.Addr, 0, len(s.transports))
+	for t := range s.transports {
+		addrs = append(addrs, t.Addr())
+	}
+
+	return addrs
+}
+
+// shuttingDown 服务是否正在关闭
+func (s *Server) shuttingDown() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.inShutdown
+}
+
+// trackTransport 记录已经开始监听的传输层, 关闭服务时统一关闭
+func (s *Server) trackTransport(t Transport) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.inShutdown {
+		return false
+	}
+
+	s.transports[t] = struct{}{}
+
+	return true
+}
+
+// trackConn 记录活跃连接, 服务正在关闭时拒绝新的连接
+func (s *Server) trackConn(c Conn, add bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if add {
+		if s.inShutdown {
+			return false
+		}
+
+		s.conns[c] = nil
+		s.wg.Add(1)
+	} else {
+		delete(s.conns, c)
+		s.wg.Done()
+	}
+
+	return true
+}
+
+// bindConnCancel 记录取消连接上所有请求的cancel, 已经强制关闭时直接取消
+func (s *Server) bindConnCancel(c Conn, cancel context.CancelFunc) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.forced {
+		cancel()
+		return
+	}
+
+	if _, ok := s.conns[c]; ok {
+		s.conns[c] = cancel
+	}
+}
+
+// handleHeartbeat 处理心跳包, 不经过路由和中间件
+func (s *Server) handleHeartbeat(ctx Context) {
+	defer s.writeResponse(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			ctx.Error(StatusInternalServerError, fmt.Sprint(r))
+		}
+	}()
+
+	if s.options.pingHandler != nil {
+		s.options.pingHandler.Handle(ctx)
+	}
+}
+
+// handleHandshake 协商协议版本, 不支持握手的旧版本服务端会回复错误, 客户端继续使用v1.
+// 回复仍然使用v1, 写回复之前切换连接读取和推送使用的版本
+func (s *Server) handleHandshake(ctx *ContextConn, remote *Connection) {
+	version := ProtocolV1
+	vc, ok := ctx.Conn.(versionedConn)
+	if v, err := strconv.Atoi(ctx.GetRequestProperty(protocolProperty)); ok && err == nil && v >= ProtocolV2 {
+		version = ProtocolV2
+	}
+
+	ctx.SetResponseProperty(protocolProperty, strconv.Itoa(version))
+	ctx.Success(nil)
+
+	remote.upgrade(uint8(version), func() {
+		if ok {
+			vc.setProtocol(uint8(version))
+		}
+
+		s.writeResponse(ctx)
+	})
+}
+
+// writeResponse 中间件链执行结束以后把回复写到连接, 然后执行通过OnResponse注册的回调
+func (s *Server) writeResponse(ctx Context) {
+	r, ok := ctx.(responder)
+	if !ok {
+		return
+	}
+
+	if !r.replied() {
+		ctx.Success(nil) // If it don't call the function of Success or Error, deal it by default
+	}
+
+	if err := r.writeResponse(); err != nil {
+		fmt.Printf("write response error: %s\n", err.Error())
+	}
+
+	for _, fn := range r.responseHooks() {
+		s.runResponseHook(ctx, fn)
+	}
+}
+
+// runResponseHook 回复已经发送, 回调中的panic只记录, 不影响其它回调
+func (s *Server) runResponseHook(ctx Context, fn func(Context)) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("response hook panic: %v\n", r)
+		}
+	}()
+
+	fn(ctx)
+}
+
 // 绑定路由
 func (s *Server) BindRouter(r *Router) {
 	s.registerInternalRouter(r)
@@ -85,43 +430,47 @@ func (s *Server) BindRouter(r *Router) {
 
 // 注册内部路由
 func (s *Server) registerInternalRouter(r *Router) *Router {
-	r.handlerContainer[OperatorRegisterListener] = HandlerFunc(func(ctx Context) {
+	r.handle(OperatorRegisterListener, "/linker/register_listener", HandlerFunc(func(ctx Context) {
 		var topic string
 
 		coder, err := codec.NewCoder(codec.String)
 		if err != nil {
 			ctx.Error(StatusInternalServerError, err.Error())
+			return
 		}
 
 		if err := coder.Decoder(ctx.RawBody(), &topic); err != nil {
 			ctx.Error(StatusInternalServerError, err.Error())
+			return
 		}
 
 		if err := ctx.Subscribe(topic, func(bytes []byte) {
 			if _, err := ctx.Write(topic, bytes); err != nil {
-				ctx.Error(StatusInternalServerError, err.Error())
+				fmt.Printf("write message error: %s\n", err.Error())
 			}
 		}); err != nil {
 			ctx.Error(StatusInternalServerError, err.Error())
 		}
-	})
+	}))
 
-	r.handlerContainer[OperatorRemoveListener] = HandlerFunc(func(ctx Context) {
+	r.handle(OperatorRemoveListener, "/linker/remove_listener", HandlerFunc(func(ctx Context) {
 		var topic string
 
 		coder, err := codec.NewCoder(codec.String)
 		if err != nil {
 			ctx.Error(StatusInternalServerError, err.Error())
+			return
 		}
 
 		if err := coder.Decoder(ctx.RawBody(), &topic); err != nil {
 			ctx.Error(StatusInternalServerError, err.Error())
+			return
 		}
 
 		if err := ctx.UnSubscribe(topic); err != nil {
 			ctx.Error(StatusInternalServerError, err.Error())
 		}
-	})
+	}))
 
 	return r
 }
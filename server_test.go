@@ -0,0 +1,629 @@
+package linker_test
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/broker/memory"
+	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker/client/export"
+	"github.com/wpajqz/linker/codec"
+	"github.com/wpajqz/linker/utils/convert"
+)
+
+// runServer 在随机端口上启动服务, 返回监听的地址
+func runServer(t *testing.T, router *linker.Router, opts ...linker.Option) (*linker.Server, string, <-chan error) {
+	t.Helper()
+
+	opts = append([]linker.Option{linker.WithTCPEndpoint(linker.Endpoint{Address: "127.0.0.1:0"})}, opts...)
+	s := linker.NewServer(opts...)
+	s.BindRouter(router)
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run() }()
+
+	for i := 0; i < 100; i++ {
+		if addrs := s.Addrs(); len(addrs) > 0 {
+			return s, addrs[0].String(), errc
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("server is not listening")
+	return nil, "", nil
+}
+
+func dial(t *testing.T, address string) *export.Client {
+	t.Helper()
+
+	c, err := export.NewClient(address, &client.ReadyStateCallback{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetContentType(codec.JSON)
+
+	return c
+}
+
+// writeRequest 在连接上发送一个请求
+func writeRequest(t *testing.T, conn net.Conn, pattern string, sequence int64, header, body []byte) {
+	t.Helper()
+
+	p, err := linker.NewPacket(crc32.ChecksumIEEE([]byte(pattern)), sequence, header, body, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Write(p.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readReply 读取连接上的下一个数据包
+func readReply(t *testing.T, conn net.Conn) linker.Packet {
+	t.Helper()
+
+	head := make([]byte, 20)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		t.Fatal(err)
+	}
+
+	header := make([]byte, convert.BytesToUint32(head[12:16]))
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+
+	body := make([]byte, convert.BytesToUint32(head[16:20]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatal(err)
+	}
+
+	p, _ := linker.NewPacket(convert.BytesToUint32(head[:4]), convert.BytesToInt64(head[4:12]), header, body, nil)
+
+	return p
+}
+
+func TestServerShutdown(t *testing.T) {
+	closed := make(chan string, 4)
+
+	router := linker.NewRouter()
+	router.Route("/slow", linker.HandlerFunc(func(ctx linker.Context) {
+		time.Sleep(300 * time.Millisecond)
+		ctx.Success("done")
+	}))
+
+	s, address, errc := runServer(t, router, linker.WithOnClose(linker.HandlerFunc(func(ctx linker.Context) {
+		closed <- ctx.RemoteAddr()
+	})))
+
+	conn, err := net.Dial(linker.NetworkTCP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	writeRequest(t, conn, "/slow", 1, nil, nil)
+	time.Sleep(100 * time.Millisecond)
+
+	// 正在处理的请求完成以后Shutdown才返回
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if p := readReply(t, conn); p.Sequence != 1 || string(p.Body) != `"done"` {
+		t.Errorf("unexpected reply: %d %s", p.Sequence, p.Body)
+	}
+
+	for called := false; !called; {
+		select {
+		case addr := <-closed:
+			called = addr == conn.LocalAddr().String()
+		default:
+			t.Fatal("close handler was not called")
+		}
+	}
+
+	if err := <-errc; err != linker.ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+
+	router := linker.NewRouter()
+	router.Route("/block", linker.HandlerFunc(func(ctx linker.Context) {
+		started <- struct{}{}
+		<-release
+		ctx.Success(nil)
+	}))
+
+	s, address, errc := runServer(t, router)
+
+	conn, err := net.Dial(linker.NetworkTCP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	writeRequest(t, conn, "/block", 1, nil, nil)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+
+	// ctx到期以后剩余的连接被强制关闭
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("connection was not closed")
+	} else if e, ok := err.(net.Error); ok && e.Timeout() {
+		t.Error("connection was not closed")
+	}
+
+	if err := <-errc; err != linker.ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+}
+
+func TestServerShutdownForced(t *testing.T) {
+	started := make(chan struct{}, 1)
+	closed := make(chan struct{}, 1)
+
+	router := linker.NewRouter()
+	router.Route("/wait", linker.HandlerFunc(func(ctx linker.Context) {
+		started <- struct{}{}
+		<-ctx.Done()
+	}))
+
+	s, address, _ := runServer(t, router, linker.WithOnClose(linker.HandlerFunc(func(ctx linker.Context) {
+		closed <- struct{}{}
+	})))
+
+	conn, err := net.Dial(linker.NetworkTCP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	writeRequest(t, conn, "/wait", 1, nil, nil)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+
+	// 强制关闭返回时连接已经完成清理
+	select {
+	case <-closed:
+	default:
+		t.Error("close handler was not called before Shutdown returned")
+	}
+}
+
+func TestServerFrameTooLarge(t *testing.T) {
+	s, address, _ := runServer(t, linker.NewRouter(), linker.MaxBodySize(1024))
+	defer s.Shutdown(context.Background())
+
+	conn, err := net.Dial(linker.NetworkTCP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 只发送固定部分, body长度远超限制
+	head := append(convert.Uint32ToBytes(2048), convert.Int64ToBytes(7)...)
+	head = append(head, convert.Uint32ToBytes(0)...)
+	head = append(head, convert.Uint32ToBytes(1<<30)...)
+	if _, err := conn.Write(head); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	reply := make([]byte, 20)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	if seq := convert.BytesToInt64(reply[4:12]); seq != 7 {
+		t.Errorf("unexpected sequence: %d", seq)
+	}
+
+	header := make([]byte, convert.BytesToUint32(reply[12:16]))
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(header), "code=413;") {
+		t.Errorf("unexpected header: %s", header)
+	}
+
+	if _, err := io.ReadFull(conn, make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected connection to be closed, got %v", err)
+	}
+}
+
+func TestServerProtocolV2(t *testing.T) {
+	router := linker.NewRouter()
+	router.Route("/echo", linker.HandlerFunc(func(ctx linker.Context) {
+		var v string
+		if err := ctx.ParseParam(&v); err != nil {
+			ctx.Error(linker.StatusBadRequest, err.Error())
+			return
+		}
+
+		ctx.Success(fmt.Sprintf("%s v%d", v, ctx.Protocol()))
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	c := dial(t, address)
+	if c.Protocol() != linker.ProtocolV2 {
+		t.Fatalf("expected protocol v2, got %d", c.Protocol())
+	}
+
+	c.SetChecksum(true)
+
+	reply := make(chan string, 1)
+	err := c.SyncSend("/echo", "hello", client.RequestStatusCallback{
+		Success: func(header, body []byte) { reply <- string(body) },
+		Error:   func(code int, message string) { reply <- message },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := <-reply; v != `"hello v2"` {
+		t.Errorf("unexpected reply: %s", v)
+	}
+}
+
+func TestClientChecksumToggle(t *testing.T) {
+	router := linker.NewRouter()
+	router.Route("/ping", linker.HandlerFunc(func(ctx linker.Context) {
+		ctx.Success("pong")
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	c := dial(t, address)
+
+	// 发送请求的同时切换校验
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			c.SetChecksum(i%2 == 0)
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		var v string
+		if err := c.Call(context.Background(), "/ping", nil, &v); err != nil || v != "pong" {
+			t.Fatalf("unexpected reply: %s, %v", v, err)
+		}
+	}
+
+	<-done
+}
+
+func TestServerUnsupportedFlags(t *testing.T) {
+	router := linker.NewRouter()
+	router.Route("/ping", linker.HandlerFunc(func(ctx linker.Context) {
+		ctx.Success("pong")
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	conn, err := net.Dial(linker.NetworkTCP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// v1握手以后按照v2读写
+	handshake, _ := linker.NewPacket(linker.OperatorHandshake, 1, linker.Header{"protocol": {"2"}}.EncodeLegacy(), nil, nil)
+	if _, err := conn.Write(handshake.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = readReply(t, conn)
+
+	send := func(sequence int64, flags uint8) linker.Header {
+		p, err := linker.NewPacket(crc32.ChecksumIEEE([]byte("/ping")), sequence, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p.Version, p.Flags = linker.ProtocolV2, flags
+		if _, err := conn.Write(p.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+
+		rp, err := linker.ReadPacket(conn, linker.ProtocolV2, linker.FrameLimits{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rp.Sequence != sequence {
+			t.Fatalf("unexpected sequence: %d", rp.Sequence)
+		}
+
+		header, err := linker.DecodeHeader(rp.Header, rp.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return header
+	}
+
+	// 还不支持压缩和加密, 回复错误以后连接可以继续使用
+	for i, flags := range []uint8{linker.FlagCompressed, linker.FlagEncrypted} {
+		if code := send(int64(i+2), flags).Get("code"); code != "400" {
+			t.Errorf("flags %d: unexpected code %q", flags, code)
+		}
+	}
+
+	if code := send(4, 0).Get("code"); code != "" {
+		t.Errorf("unexpected code after rejected frames: %s", code)
+	}
+}
+
+func TestServerMagicOperator(t *testing.T) {
+	// operator的高16位和v2的magic相同, 连接按照握手协商的版本解析, 不会混淆
+	table := linker.OperatorTable{"/magic": 0x4C4B0201}
+
+	router := linker.NewRouter().Operators(table)
+	router.Route("/magic", linker.HandlerFunc(func(ctx linker.Context) {
+		ctx.Success(fmt.Sprintf("v%d", ctx.Protocol()))
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	c := dial(t, address)
+	c.SetOperatorTable(table)
+
+	var v string
+	if err := c.Call(context.Background(), "/magic", nil, &v); err != nil || v != "v2" {
+		t.Errorf("unexpected reply: %s, %v", v, err)
+	}
+
+	// 没有握手的v1客户端
+	conn, err := net.Dial(linker.NetworkTCP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	p, _ := linker.NewPacket(table["/magic"], 1, nil, nil, nil)
+	if _, err := conn.Write(p.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	reply, err := linker.ReadPacket(conn, linker.ProtocolV1, linker.FrameLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply.Operator != table["/magic"] || string(reply.Body) != `"v1"` {
+		t.Errorf("unexpected reply: %d %s", reply.Operator, reply.Body)
+	}
+}
+
+func TestClientConcurrentSyncSend(t *testing.T) {
+	router := linker.NewRouter()
+	router.Route("/echo", linker.HandlerFunc(func(ctx linker.Context) {
+		var n int
+		if err := ctx.ParseParam(&n); err != nil {
+			ctx.Error(linker.StatusBadRequest, err.Error())
+			return
+		}
+
+		// 回复的顺序和请求不同, 奇数回复错误
+		time.Sleep(time.Duration(n%5) * time.Millisecond)
+		if n%2 == 1 {
+			ctx.Error(linker.StatusBadRequest, fmt.Sprint(n))
+			return
+		}
+
+		ctx.Success(n)
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	c := dial(t, address)
+
+	errc := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		go func(n int) {
+			var got string
+			err := c.SyncSend("/echo", n, client.RequestStatusCallback{
+				Success: func(header, body []byte) { got = "ok:" + string(body) },
+				Error:   func(code int, message string) { got = fmt.Sprintf("%d:%s", code, message) },
+			})
+
+			want := fmt.Sprintf("ok:%d", n)
+			if n%2 == 1 {
+				want = fmt.Sprintf("400:%d", n)
+			}
+
+			if err == nil && got != want {
+				err = fmt.Errorf("got %s, want %s", got, want)
+			}
+
+			errc <- err
+		}(i)
+	}
+
+	for i := 0; i < 100; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("requests were serialized or lost")
+		}
+	}
+}
+
+func TestClientResponseHeader(t *testing.T) {
+	router := linker.NewRouter()
+	router.Route("/echo", linker.HandlerFunc(func(ctx linker.Context) {
+		var n int
+		if err := ctx.ParseParam(&n); err != nil {
+			ctx.Error(linker.StatusBadRequest, err.Error())
+			return
+		}
+
+		time.Sleep(time.Duration(n%5) * time.Millisecond)
+		ctx.SetResponseProperty("n", fmt.Sprint(n))
+		ctx.Success(n)
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	c := dial(t, address)
+	c.SetContentType(codec.JSON)
+
+	// 修改共享的请求属性和并发的请求互不影响, 每个请求收到自己的回复header
+	errc := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		go func(n int) {
+			c.SetRequestProperty("client", fmt.Sprint(n))
+
+			var (
+				v      int
+				header linker.Header
+			)
+
+			err := c.Call(context.Background(), "/echo", n, &v, export.ReceiveHeader(&header))
+			if err == nil && (v != n || header.Get("n") != fmt.Sprint(n)) {
+				err = fmt.Errorf("got %d with header %v, want %d", v, header, n)
+			}
+
+			errc <- err
+		}(i)
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("requests were lost")
+		}
+	}
+}
+
+func TestClientCall(t *testing.T) {
+	router := linker.NewRouter()
+	router.Route("/add", linker.HandlerFunc(func(ctx linker.Context) {
+		var args [2]int
+		if err := ctx.ParseParam(&args); err != nil {
+			ctx.Error(linker.StatusBadRequest, err.Error())
+			return
+		}
+
+		if args[0] < 0 {
+			ctx.Error(linker.StatusBadRequest, "negative")
+			return
+		}
+
+		ctx.SetResponseProperty("trace", ctx.GetRequestProperty("trace"))
+		ctx.Success(args[0] + args[1])
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	c, err := client.NewClient([]string{address}, client.InitialCapacity(1), client.MaxCapacity(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var (
+		sum    int
+		header linker.Header
+	)
+
+	err = c.Call(ctx, "/add", [2]int{1, 2}, &sum, export.WithHeader(linker.Header{"trace": {"t1"}}), export.ReceiveHeader(&header))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sum != 3 || header.Get("trace") != "t1" {
+		t.Errorf("unexpected reply: %d, %v", sum, header)
+	}
+
+	f := c.Go(ctx, "/add", [2]int{-1, 2}, &sum)
+	err = f.Wait()
+	if e, ok := err.(*linker.Error); !ok || e.Code != linker.StatusBadRequest || e.Message != "negative" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestServerAdvertiseAddress(t *testing.T) {
+	b := memory.NewBroker()
+
+	// 监听所有地址时不能把监听的地址注册给客户端
+	s := linker.NewServer(linker.WithTCPEndpoint(linker.Endpoint{Address: "0.0.0.0:0"}), linker.Broker(b), linker.WithService("who"))
+	s.BindRouter(linker.NewRouter())
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run() }()
+
+	select {
+	case err := <-errc:
+		if err == nil || !strings.Contains(err.Error(), "advertise address") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		_ = s.Shutdown(context.Background())
+		t.Fatal("unroutable address was registered")
+	}
+
+	advertised, _, runErr := runServer(t, linker.NewRouter(),
+		linker.WithTCPEndpoint(linker.Endpoint{Address: "0.0.0.0:0"}),
+		linker.Broker(b), linker.WithService("who"), linker.AdvertiseAddress("10.0.0.1:8080"),
+	)
+	defer advertised.Shutdown(context.Background())
+
+	select {
+	case err := <-runErr:
+		t.Fatalf("unexpected error: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -1,5 +1,7 @@
 package linker
 
+import "fmt"
+
 // linker status codes as registered.
 const (
 	StatusContinue           = 100 // RFC 7231, 6.2.1
@@ -139,3 +141,13 @@ var statusText = map[int]string{
 func StatusText(code int) string {
 	return statusText[code]
 }
+
+// Error 对端回复的错误状态
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("code %d: %s", e.Code, e.Message)
+}
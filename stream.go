@@ -0,0 +1,390 @@
+package linker
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/wpajqz/linker/codec"
+)
+
+// 控制消息通过该属性传递指令
+const (
+	controlProperty = "control"
+	controlCancel   = "cancel"
+	controlWindow   = "window"
+	creditProperty  = "credit"
+)
+
+// StreamWindow 双向流每个方向的初始窗口, 单位是消息的个数.
+// 发送方每发送一个消息消耗一个额度, 接收方处理以后通过window控制消息归还
+const StreamWindow = 32
+
+var (
+	// ErrStreamNotSupported 客户端使用v1协议, 无法区分流式回复的数据包
+	ErrStreamNotSupported = errors.New("linker: stream requires protocol v2")
+	// ErrStreamClosed 流已经结束
+	ErrStreamClosed = errors.New("linker: stream closed")
+)
+
+type (
+	// Stream 流式回复, 每次Send发送一个和请求的operator, sequence对应的数据包, Close发送结束标记.
+	// 客户端通过OpenStream打开的双向流可以同时Recv客户端的消息. handler返回时没有Close的流由Server关闭
+	Stream interface {
+		Send(body interface{}) error
+		// Recv 读取客户端的下一个消息并解析到data, 客户端结束发送以后返回io.EOF
+		Recv(data interface{}) error
+		Close() error
+	}
+
+	serverStream struct {
+		ctx *ContextConn
+		// 只有双向流才有, 客户端发送的消息
+		inbound chan []byte
+		eof     chan struct{}
+		eofOnce sync.Once
+		// 发送窗口, 客户端读取以后归还额度, 读得慢时Send阻塞, 不会占满连接
+		window    *window
+		mutex     sync.Mutex
+		consumed  int
+		violation func()
+	}
+
+	// window 发送窗口, 额度用完以后阻塞直到对方归还
+	window struct {
+		mutex   sync.Mutex
+		credits int
+		wake    chan struct{}
+		// 连接不再读取以后关闭, 不会再收到归还的额度
+		closed    chan struct{}
+		closeOnce sync.Once
+	}
+
+	// requestKey 连接上一个请求的标识, 双向流也用它作为流的标识
+	requestKey struct {
+		operator uint32
+		sequence int64
+	}
+
+	// inflight 连接上正在处理的请求和打开的双向流
+	inflight struct {
+		mutex   sync.Mutex
+		cancels map[requestKey]func()
+		streams map[requestKey]*serverStream
+		// 连接已经不再读取, 流不会再收到客户端的消息
+		closed bool
+	}
+)
+
+// Stream 开始流式回复, 多次调用返回同一个Stream
+func (c *ContextConn) Stream() (Stream, error) {
+	if c.Protocol() < ProtocolV2 {
+		return nil, ErrStreamNotSupported
+	}
+
+	c.reply.Lock()
+	defer c.reply.Unlock()
+
+	if c.stream != nil {
+		return c.stream, nil
+	}
+
+	if c.reply.written {
+		return nil, ErrStreamClosed
+	}
+
+	c.reply.streaming = true
+	c.stream = &serverStream{ctx: c, window: newWindow(StreamWindow)}
+	c.registerStream(c.stream)
+
+	return c.stream, nil
+}
+
+// openStream 客户端打开双向流时由Server调用, 之后handler通过Stream得到它
+func (c *ContextConn) openStream() *serverStream {
+	c.reply.Lock()
+	defer c.reply.Unlock()
+
+	c.reply.streaming = true
+	c.stream = &serverStream{
+		ctx:     c,
+		inbound: make(chan []byte, StreamWindow),
+		eof:     make(chan struct{}),
+		window:  newWindow(StreamWindow),
+	}
+	c.registerStream(c.stream)
+
+	return c.stream
+}
+
+// registerStream 把流交给Server, 之后客户端归还的额度和发送的消息才能找到它
+func (c *ContextConn) registerStream(s *serverStream) {
+	if c.onStream != nil {
+		c.onStream(s)
+	}
+}
+
+// Send 发送一个数据包, 客户端取消或者断开连接以后返回ctx.Err()
+func (s *serverStream) Send(body interface{}) error {
+	c := s.ctx
+	if err := c.Err(); err != nil {
+		return err
+	}
+
+	r, err := codec.NewCoder(c.options.contentType)
+	if err != nil {
+		return err
+	}
+
+	data, err := r.Encoder(body)
+	if err != nil {
+		return err
+	}
+
+	p, err := NewPacket(c.operateType, c.sequence, nil, data, c.options.pluginForPacketSender)
+	if err != nil {
+		return err
+	}
+
+	p.Version, p.Flags = c.version, c.flags&FlagChecksum|FlagStream
+
+	if err := s.window.acquire(c.Done()); err != nil {
+		return err
+	}
+
+	c.streamMutex.Lock()
+	defer c.streamMutex.Unlock()
+
+	c.reply.Lock()
+	written := c.reply.written
+	c.reply.Unlock()
+
+	if written {
+		return ErrStreamClosed
+	}
+
+	return c.Conn.WritePacket(p)
+}
+
+// Recv 读取客户端的下一个消息, 处理过半个窗口的消息以后归还额度
+func (s *serverStream) Recv(data interface{}) error {
+	if s.inbound == nil {
+		return io.EOF
+	}
+
+	var body []byte
+	select {
+	case body = <-s.inbound:
+	default:
+		select {
+		case body = <-s.inbound:
+		case <-s.eof:
+			// 结束标记之前的消息优先返回
+			select {
+			case body = <-s.inbound:
+			default:
+				return io.EOF
+			}
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+
+	if err := s.grant(); err != nil {
+		return err
+	}
+
+	r, err := codec.NewCoder(s.ctx.options.contentType)
+	if err != nil {
+		return err
+	}
+
+	return r.Decoder(body, data)
+}
+
+// grant 通知客户端可以继续发送
+func (s *serverStream) grant() error {
+	s.mutex.Lock()
+	s.consumed++
+	n := s.consumed
+	if n < StreamWindow/2 {
+		s.mutex.Unlock()
+		return nil
+	}
+
+	s.consumed = 0
+	s.mutex.Unlock()
+
+	c := s.ctx
+	header := Header{controlProperty: {controlWindow}, creditProperty: {strconv.Itoa(n)}}
+	p, err := NewPacket(c.operateType, c.sequence, header.Encode(), nil, c.options.pluginForPacketSender)
+	if err != nil {
+		return err
+	}
+
+	p.Version, p.Flags = c.version, c.flags&FlagChecksum|FlagControl
+
+	return c.Conn.WritePacket(p)
+}
+
+// deliver 把客户端发送的消息交给Recv, 客户端超过窗口发送或者向只读的流发送时取消流
+func (s *serverStream) deliver(p Packet) {
+	if s.inbound == nil {
+		s.violation()
+		return
+	}
+
+	if p.Flags&FlagEndOfStream != 0 {
+		s.closeSend()
+		return
+	}
+
+	select {
+	case s.inbound <- p.Body:
+	default:
+		s.violation()
+	}
+}
+
+// closeSend 客户端结束发送
+func (s *serverStream) closeSend() {
+	if s.eof == nil {
+		return
+	}
+
+	s.eofOnce.Do(func() { close(s.eof) })
+}
+
+// Close 发送结束标记, 携带Success或Error记录的状态
+func (s *serverStream) Close() error {
+	if !s.ctx.replied() {
+		s.ctx.Success(nil)
+	}
+
+	return s.ctx.writeResponse()
+}
+
+func newWindow(credits int) *window {
+	return &window{credits: credits, wake: make(chan struct{}, 1), closed: make(chan struct{})}
+}
+
+// acquire 获取一个发送额度, done关闭或者窗口关闭时返回错误
+func (w *window) acquire(done <-chan struct{}) error {
+	for {
+		w.mutex.Lock()
+		if w.credits > 0 {
+			w.credits--
+			w.mutex.Unlock()
+			return nil
+		}
+		w.mutex.Unlock()
+
+		select {
+		case <-w.wake:
+		case <-done:
+			return ErrStreamClosed
+		case <-w.closed:
+			return ErrStreamClosed
+		}
+	}
+}
+
+// release 对方归还额度
+func (w *window) release(n int) {
+	w.mutex.Lock()
+	w.credits += n
+	w.mutex.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close 唤醒等待额度的发送方
+func (w *window) close() {
+	w.closeOnce.Do(func() { close(w.closed) })
+}
+
+func newInflight() *inflight {
+	return &inflight{cancels: make(map[requestKey]func()), streams: make(map[requestKey]*serverStream)}
+}
+
+func (f *inflight) add(key requestKey, cancel func()) {
+	f.mutex.Lock()
+	f.cancels[key] = cancel
+	f.mutex.Unlock()
+}
+
+func (f *inflight) remove(key requestKey) {
+	f.mutex.Lock()
+	delete(f.cancels, key)
+	delete(f.streams, key)
+	f.mutex.Unlock()
+}
+
+func (f *inflight) cancel(key requestKey) {
+	f.mutex.Lock()
+	cancel, ok := f.cancels[key]
+	f.mutex.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+func (f *inflight) addStream(key requestKey, s *serverStream) {
+	f.mutex.Lock()
+	f.streams[key] = s
+	closed := f.closed
+	f.mutex.Unlock()
+
+	if closed {
+		s.closeSend()
+		s.window.close()
+	}
+}
+
+// close 连接的读取结束时调用, 阻塞在Recv上的handler收到io.EOF, 等待额度的Send返回ErrStreamClosed,
+// 关闭服务时不需要等到Shutdown的ctx到期才能结束
+func (f *inflight) close() {
+	f.mutex.Lock()
+	f.closed = true
+	streams := make([]*serverStream, 0, len(f.streams))
+	for _, s := range f.streams {
+		streams = append(streams, s)
+	}
+	f.mutex.Unlock()
+
+	for _, s := range streams {
+		s.closeSend()
+		s.window.close()
+	}
+}
+
+func (f *inflight) stream(key requestKey) *serverStream {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.streams[key]
+}
+
+// handleControl 处理客户端的控制消息: 取消正在处理的请求, 归还流的发送额度
+func (s *Server) handleControl(ctx Context, rp Packet, requests *inflight) {
+	key := requestKey{operator: rp.Operator, sequence: rp.Sequence}
+
+	switch ctx.GetRequestProperty(controlProperty) {
+	case controlCancel:
+		requests.cancel(key)
+	case controlWindow:
+		n, err := strconv.Atoi(ctx.GetRequestProperty(creditProperty))
+		if err != nil || n <= 0 {
+			return
+		}
+
+		if st := requests.stream(key); st != nil {
+			st.window.release(n)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package linker_test
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+)
+
+func TestServerStream(t *testing.T) {
+	cancelled := make(chan struct{})
+
+	router := linker.NewRouter()
+	router.Route("/count", linker.HandlerFunc(func(ctx linker.Context) {
+		stream, err := ctx.Stream()
+		if err != nil {
+			ctx.Error(linker.StatusInternalServerError, err.Error())
+			return
+		}
+
+		for i := 1; i <= 3; i++ {
+			if err := stream.Send(i); err != nil {
+				return
+			}
+		}
+
+		ctx.Error(linker.StatusBadRequest, "done")
+	}))
+
+	router.Route("/forever", linker.HandlerFunc(func(ctx linker.Context) {
+		stream, err := ctx.Stream()
+		if err != nil {
+			ctx.Error(linker.StatusInternalServerError, err.Error())
+			return
+		}
+
+		for i := 0; ; i++ {
+			if err := stream.Send(i); err != nil {
+				close(cancelled)
+				return
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	c := dial(t, address)
+
+	r, err := c.Stream("/count", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int
+	for r.Next() {
+		var v int
+		if err := r.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, v)
+	}
+
+	if len(got) != 3 || got[2] != 3 {
+		t.Errorf("unexpected items: %v", got)
+	}
+
+	if err := r.Err(); err == nil || err.Error() != "code 400: done" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	r, err = c.Stream("/forever", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2 && r.Next(); i++ {
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("handler was not cancelled")
+	}
+}
+
+func TestServerBidiStream(t *testing.T) {
+	router := linker.NewRouter()
+	router.Route("/double", linker.HandlerFunc(func(ctx linker.Context) {
+		stream, err := ctx.Stream()
+		if err != nil {
+			ctx.Error(linker.StatusInternalServerError, err.Error())
+			return
+		}
+
+		for {
+			var v int
+			err := stream.Recv(&v)
+			if err == io.EOF {
+				return
+			}
+
+			if err != nil {
+				ctx.Error(linker.StatusBadRequest, err.Error())
+				return
+			}
+
+			if err := stream.Send(v * 2); err != nil {
+				return
+			}
+		}
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	c := dial(t, address)
+
+	stream, err := c.OpenStream("/double")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	// 超过窗口大小, 需要双方归还额度才能发完
+	n := linker.StreamWindow * 3
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := stream.Send(i); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		if err := stream.CloseSend(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	for i := 0; ; i++ {
+		var v int
+		err := stream.Recv(&v)
+		if err == io.EOF {
+			if i != n {
+				t.Errorf("received %d items, want %d", i, n)
+			}
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v != i*2 {
+			t.Fatalf("item %d: got %d", i, v)
+		}
+	}
+}
+
+func TestServerStreamWindow(t *testing.T) {
+	const total = 200
+
+	var sent int64
+
+	router := linker.NewRouter()
+	router.Route("/flood", linker.HandlerFunc(func(ctx linker.Context) {
+		stream, err := ctx.Stream()
+		if err != nil {
+			ctx.Error(linker.StatusInternalServerError, err.Error())
+			return
+		}
+
+		for i := 0; i < total; i++ {
+			if err := stream.Send(i); err != nil {
+				return
+			}
+
+			atomic.AddInt64(&sent, 1)
+		}
+	}))
+
+	router.Route("/ping", linker.HandlerFunc(func(ctx linker.Context) {
+		ctx.Success("pong")
+	}))
+
+	s, address, _ := runServer(t, router)
+	defer s.Shutdown(context.Background())
+
+	c := dial(t, address)
+
+	r, err := c.Stream("/flood", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// 不读取的流只暂停自己, 同一个连接上的其它请求不受影响
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt64(&sent); n > linker.StreamWindow {
+		t.Errorf("server sent %d messages without credit", n)
+	}
+
+	var v string
+	if err := c.Call(context.Background(), "/ping", nil, &v); err != nil || v != "pong" {
+		t.Fatalf("unexpected reply: %s, %v", v, err)
+	}
+
+	var got int
+	for r.Next() {
+		got++
+	}
+
+	if got != total || r.Err() != nil {
+		t.Errorf("got %d messages, err %v", got, r.Err())
+	}
+}
+
+func TestServerBidiStreamShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	errs := make(chan error, 1)
+
+	router := linker.NewRouter()
+	router.Route("/echo", linker.HandlerFunc(func(ctx linker.Context) {
+		stream, err := ctx.Stream()
+		if err != nil {
+			ctx.Error(linker.StatusInternalServerError, err.Error())
+			return
+		}
+
+		started <- struct{}{}
+
+		var v int
+		errs <- stream.Recv(&v)
+	}))
+
+	s, address, _ := runServer(t, router)
+
+	c := dial(t, address)
+
+	stream, err := c.OpenStream("/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	<-started
+
+	// 连接不再读取以后Recv返回io.EOF, 不需要等到ctx到期
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	if err := <-errs; err != io.EOF {
+		t.Errorf("unexpected recv error: %v", err)
+	}
+}
+
+func TestServerStreamWindowShutdown(t *testing.T) {
+	errs := make(chan error, 1)
+
+	router := linker.NewRouter()
+	router.Route("/flood", linker.HandlerFunc(func(ctx linker.Context) {
+		stream, err := ctx.Stream()
+		if err != nil {
+			ctx.Error(linker.StatusInternalServerError, err.Error())
+			return
+		}
+
+		for i := 0; ; i++ {
+			if err := stream.Send(i); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}))
+
+	s, address, _ := runServer(t, router)
+
+	c := dial(t, address)
+
+	r, err := c.Stream("/flood", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// 客户端不读取, 额度用完以后Send阻塞
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	if err := <-errs; err != linker.ErrStreamClosed {
+		t.Errorf("unexpected send error: %v", err)
+	}
+}
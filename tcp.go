@@ -1,195 +1,135 @@
 package linker
 
 import (
-	"context"
+	"crypto/tls"
 	"fmt"
-	"io"
 	"net"
+	"sync"
 	"time"
-
-	uuid "github.com/satori/go.uuid"
-	"github.com/wpajqz/linker/utils/convert"
 )
 
-func (s *Server) handleTCPConnection(conn *net.TCPConn) error {
-	ctx := &ContextTcp{common: common{Context: context.Background()}, Conn: conn}
-	if s.options.constructHandler != nil {
-		s.options.constructHandler.Handle(ctx)
-	}
+// 没有设置超时时间时, TLS握手的超时时间
+const defaultHandshakeTimeout = 10 * time.Second
+
+// tcpTransport tcp传输层
+type tcpTransport struct {
+	address   string
+	tlsConfig *TLSConfig
+	tls       *tls.Config
+	options   Options
+	mutex     sync.Mutex
+	closed    bool
+	listener  *net.TCPListener
+}
 
-	ctx.Set(nodeID, uuid.NewV4().String())
+func newTCPTransport(address string, tlsConfig *TLSConfig, options Options) *tcpTransport {
+	return &tcpTransport{address: address, tlsConfig: tlsConfig, options: options}
+}
 
-	defer func() {
-		if s.options.destructHandler != nil {
-			s.options.destructHandler.Handle(ctx)
+func (t *tcpTransport) Listen() error {
+	if t.tlsConfig != nil {
+		config, err := t.tlsConfig.build()
+		if err != nil {
+			return err
 		}
 
-		if err := ctx.UnSubscribeAll(); err != nil {
-			ctx.Error(StatusInternalServerError, err.Error())
-		}
+		t.tls = config
+	}
 
-		_ = conn.Close()
-	}()
+	tcpAddr, err := net.ResolveTCPAddr(NetworkTCP, t.address)
+	if err != nil {
+		return err
+	}
 
-	if s.options.readBufferSize > 0 {
-		err := conn.SetReadBuffer(s.options.readBufferSize)
-		if err != nil {
-			return err
-		}
+	t.listener, err = net.ListenTCP(NetworkTCP, tcpAddr)
+	if err != nil {
+		return err
 	}
 
-	if s.options.writeBufferSize > 0 {
-		err := conn.SetWriteBuffer(s.options.writeBufferSize)
-		if err != nil {
-			return err
-		}
+	if t.tls != nil {
+		fmt.Printf("Listening and serving TCP with TLS on %s\n", t.listener.Addr())
+	} else {
+		fmt.Printf("Listening and serving TCP on %s\n", t.listener.Addr())
 	}
 
-	var (
-		bType         = make([]byte, 4)
-		bSequence     = make([]byte, 8)
-		bHeaderLength = make([]byte, 4)
-		bBodyLength   = make([]byte, 4)
-		sequence      int64
-		headerLength  uint32
-		bodyLength    uint32
-	)
+	return nil
+}
 
+func (t *tcpTransport) Serve(handler func(Conn)) error {
 	for {
-		if s.options.timeout != 0 {
-			err := conn.SetDeadline(time.Now().Add(s.options.timeout))
-			if err != nil {
-				return err
+		conn, err := t.listener.AcceptTCP()
+		if err != nil {
+			if t.isClosed() {
+				return ErrServerClosed
 			}
-		}
-
-		if _, err := io.ReadFull(conn, bType); err != nil {
-			return err
-		}
-
-		if _, err := io.ReadFull(conn, bSequence); err != nil {
-			return err
-		}
 
-		if _, err := io.ReadFull(conn, bHeaderLength); err != nil {
-			return err
-		}
-
-		if _, err := io.ReadFull(conn, bBodyLength); err != nil {
-			return err
+			continue
 		}
 
-		sequence = convert.BytesToInt64(bSequence)
-		headerLength = convert.BytesToUint32(bHeaderLength)
-		bodyLength = convert.BytesToUint32(bBodyLength)
-
-		header := make([]byte, headerLength)
-		if _, err := io.ReadFull(conn, header); err != nil {
-			return err
+		if t.options.readBufferSize > 0 {
+			if err := conn.SetReadBuffer(t.options.readBufferSize); err != nil {
+				_ = conn.Close()
+				continue
+			}
 		}
 
-		body := make([]byte, bodyLength)
-		if _, err := io.ReadFull(conn, body); err != nil {
-			return err
+		if t.options.writeBufferSize > 0 {
+			if err := conn.SetWriteBuffer(t.options.writeBufferSize); err != nil {
+				_ = conn.Close()
+				continue
+			}
 		}
 
-		rp, err := NewPacket(convert.BytesToUint32(bType), sequence, header, body, s.options.pluginForPacketReceiver)
-		if err != nil {
-			return err
+		if t.tls == nil {
+			go handler(newStreamConn(conn, t.options))
+			continue
 		}
 
-		ctx = NewContextTcp(ctx.Context, conn, rp.Operator, rp.Sequence, rp.Header, rp.Body, s.options)
-		go s.handleTCPPacket(ctx, rp)
+		go t.handshake(tls.Server(conn, t.tls), handler)
 	}
 }
 
-func (s *Server) handleTCPPacket(ctx Context, rp Packet) {
-	defer func() {
-		if r := recover(); r != nil {
-			var errMsg string
-
-			switch v := r.(type) {
-			case string:
-				errMsg = v
-			case error:
-				errMsg = v.Error()
-			default:
-				errMsg = StatusText(StatusInternalServerError)
-			}
-
-			ctx.Set(errorTag, errMsg)
-
-			if s.options.errorHandler != nil {
-				s.options.errorHandler.Handle(ctx)
-			}
-
-			ctx.Error(StatusInternalServerError, errMsg)
-		}
-	}()
-
-	if rp.Operator == OperatorHeartbeat {
-		if s.options.pingHandler != nil {
-			s.options.pingHandler.Handle(ctx)
-		}
-
-		ctx.Success(nil)
+// handshake 完成TLS握手以后再交给Server, 保证Context中可以拿到对端证书
+func (t *tcpTransport) handshake(conn *tls.Conn, handler func(Conn)) {
+	timeout := t.options.timeout
+	if timeout == 0 {
+		timeout = defaultHandshakeTimeout
 	}
 
-	handler, ok := s.router.handlerContainer[rp.Operator]
-	if !ok {
-		ctx.Error(StatusInternalServerError, "server don't register your request.")
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		_ = conn.Close()
+		return
 	}
 
-	if rm, ok := s.router.routerMiddleware[rp.Operator]; ok {
-		for _, v := range rm {
-			ctx = v.Handle(ctx)
-		}
+	if err := conn.Handshake(); err != nil {
+		fmt.Printf("tls handshake error: %s\n", err.Error())
+		_ = conn.Close()
+		return
 	}
 
-	for _, v := range s.router.middleware {
-		ctx = v.Handle(ctx)
-		if tm, ok := v.(TerminateMiddleware); ok {
-			tm.Terminate(ctx)
-		}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return
 	}
 
-	handler.Handle(ctx)
-	ctx.Success(nil) // If it don't call the function of Success or Error, deal it by default
+	handler(newStreamConn(conn, t.options))
 }
 
-// runTCP 开始运行Tcp服务
-func (s *Server) runTCP(address string) error {
-	tcpAddr, err := net.ResolveTCPAddr(NetworkTCP, address)
-	if err != nil {
-		return err
-	}
+func (t *tcpTransport) Close() error {
+	t.mutex.Lock()
+	t.closed = true
+	t.mutex.Unlock()
 
-	listener, err := net.ListenTCP(NetworkTCP, tcpAddr)
-	if err != nil {
-		return err
-	}
-
-	defer listener.Close()
-
-	fmt.Printf("Listening and serving TCP on %s\n", address)
+	return t.listener.Close()
+}
 
-	if s.options.api != nil {
-		if err := s.options.api.Dial(NetworkTCP, address); err != nil {
-			return err
-		}
-	}
+func (t *tcpTransport) Addr() net.Addr {
+	return t.listener.Addr()
+}
 
-	for {
-		conn, err := listener.AcceptTCP()
-		if err != nil {
-			continue
-		}
+func (t *tcpTransport) isClosed() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 
-		go func(conn *net.TCPConn) {
-			err := s.handleTCPConnection(conn)
-			if err != nil && err != io.EOF {
-				fmt.Printf("tcp connection error: %s\n", err.Error())
-			}
-		}(conn)
-	}
+	return t.closed
 }
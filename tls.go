@@ -0,0 +1,84 @@
+package linker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// TLSConfig 传输层的TLS配置, Config不为空时以它为基础, 其它字段在其上补充
+type TLSConfig struct {
+	// 服务端证书和私钥文件
+	CertFile, KeyFile string
+	// 校验客户端证书使用的CA文件, 设置以后默认要求客户端提供证书(mTLS)
+	ClientCAFile string
+	ClientAuth   tls.ClientAuthType
+	// 默认TLS 1.2
+	MinVersion uint16
+	// SNI回调, 根据客户端请求的域名选择证书或者配置
+	GetCertificate     func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	GetConfigForClient func(*tls.ClientHelloInfo) (*tls.Config, error)
+	Config             *tls.Config
+}
+
+// tlsStater 使用TLS的连接实现该接口, Context通过它获取握手的状态
+type tlsStater interface {
+	TLSState() *tls.ConnectionState
+}
+
+// build 生成tls.Config
+func (c *TLSConfig) build() (*tls.Config, error) {
+	config := &tls.Config{}
+	if c.Config != nil {
+		config = c.Config.Clone()
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = append(config.Certificates, cert)
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("tls: no certificate found in " + c.ClientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	if c.ClientAuth != tls.NoClientCert {
+		config.ClientAuth = c.ClientAuth
+	}
+
+	if c.MinVersion != 0 {
+		config.MinVersion = c.MinVersion
+	} else if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+
+	if c.GetCertificate != nil {
+		config.GetCertificate = c.GetCertificate
+	}
+
+	if c.GetConfigForClient != nil {
+		config.GetConfigForClient = c.GetConfigForClient
+	}
+
+	if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, errors.New("tls: no certificate configured")
+	}
+
+	return config, nil
+}
@@ -0,0 +1,113 @@
+package linker_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker/client/export"
+	"github.com/wpajqz/linker/codec"
+)
+
+// issue 生成证书, parent为nil时生成自签名的CA证书
+func issue(t *testing.T, cn string, parent *tls.Certificate, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	ca := issue(t, "ca", nil, x509.ExtKeyUsageAny)
+	serverCert := issue(t, "server", &ca, x509.ExtKeyUsageServerAuth)
+	clientCert := issue(t, "device-1", &ca, x509.ExtKeyUsageClientAuth)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	router := linker.NewRouter()
+	router.Route("/whoami", linker.HandlerFunc(func(ctx linker.Context) {
+		cert := ctx.PeerCertificate()
+		if cert == nil {
+			ctx.Error(linker.StatusUnauthorized, "no client certificate")
+			return
+		}
+
+		ctx.Success(cert.Subject.CommonName)
+	}))
+
+	s, address, _ := runServer(t, router, linker.WithTCPEndpoint(linker.Endpoint{
+		Address: "127.0.0.1:0",
+		TLS: &linker.TLSConfig{
+			Config:     &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientCAs: pool},
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		},
+	}))
+	defer s.Shutdown(context.Background())
+
+	c, err := export.NewTLSClient(address, &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{clientCert}}, &client.ReadyStateCallback{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetContentType(codec.JSON)
+
+	reply := make(chan string, 1)
+	err = c.SyncSend("/whoami", nil, client.RequestStatusCallback{
+		Success: func(header, body []byte) { reply <- string(body) },
+		Error:   func(code int, message string) { reply <- message },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case v := <-reply:
+		if v != `"device-1"` {
+			t.Errorf("unexpected identity: %s", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply")
+	}
+}
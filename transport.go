@@ -0,0 +1,40 @@
+package linker
+
+import (
+	"net"
+	"time"
+)
+
+type (
+	// Conn 传输层的一个连接, 负责数据包的读写, Server通过它处理请求和发送回复
+	Conn interface {
+		// ReadPacket 读取下一个完整的数据包, 连接关闭或者出错时返回错误
+		ReadPacket() (Packet, error)
+		// WritePacket 把数据包写到连接, 需要保证并发安全
+		WritePacket(p Packet) error
+		// SetReadDeadline 设置读取的截止时间, Shutdown通过它中断阻塞的ReadPacket
+		SetReadDeadline(t time.Time) error
+		LocalAddr() net.Addr
+		RemoteAddr() net.Addr
+		Close() error
+	}
+
+	// versionedConn 握手以后切换读取协议版本的连接, 没有实现的Conn只使用v1
+	versionedConn interface {
+		Conn
+		// setProtocol 在读循环中调用, 之后的ReadPacket按照version解析
+		setProtocol(version uint8)
+	}
+
+	// Transport 传输层, 负责接收连接并交给Server统一处理, 新的传输方式只需要实现该接口
+	Transport interface {
+		// Listen 开始监听, 在Serve之前调用
+		Listen() error
+		// Serve 接收连接, 为每个连接在新的goroutine中调用handler, Close以后返回ErrServerClosed
+		Serve(handler func(Conn)) error
+		// Close 停止接收新的连接, 已经建立的连接由Server负责关闭
+		Close() error
+		// Addr 返回监听的地址
+		Addr() net.Addr
+	}
+)
@@ -1,132 +1,307 @@
 package linker
 
 import (
-	"context"
+	"container/list"
 	"fmt"
+	"io"
 	"net"
+	"sync"
+	"time"
+)
 
-	uuid "github.com/satori/go.uuid"
-	"github.com/wpajqz/linker/utils/convert"
+const (
+	// udp没有连接, 同一个远端地址的数据包组成一个会话, 会话空闲超过该时间以后关闭
+	defaultUDPSessionTimeout = time.Minute
+	// 默认的会话数量上限, 防止伪造源地址的数据报无限制地创建会话
+	defaultMaxUDPSessions = 4096
 )
 
-func (s *Server) handleUDPData(conn *net.UDPConn, remote *net.UDPAddr, data []byte, length int) {
-	bType := data[0:4]
-	bSequence := data[4:12]
-	bHeaderLength := data[12:16]
+type (
+	// udpTransport udp传输层, 按远端地址把数据报分发到会话上.
+	//
+	// 会话的生命周期: 远端地址的第一个合法数据报创建会话, 会话按照v1解析数据报, 握手以后切换到协商的版本.
+	// 会话空闲超过defaultUDPSessionTimeout, 或者会话数量达到上限时最久没有收到数据的会话被关闭,
+	// 关闭时和tcp连接断开一样执行OnClose并清理订阅和房间. 之后同一个地址的数据报创建新的会话,
+	// 新的会话重新按照v1解析, 客户端需要比空闲时间更频繁地发送心跳来保持会话
+	udpTransport struct {
+		address  string
+		options  Options
+		mutex    sync.Mutex
+		closed   bool
+		conn     *net.UDPConn
+		sessions map[string]*udpSession
+		// 按照最近收到数据的时间排列的会话, 最前面的最久没有收到数据
+		idle *list.List
+		wg   sync.WaitGroup
+	}
 
-	sequence := convert.BytesToInt64(bSequence)
-	headerLength := convert.BytesToUint32(bHeaderLength)
+	// udpSession 一个远端地址对应的会话, 作为Conn交给Server处理
+	udpSession struct {
+		transport *udpTransport
+		remote    *net.UDPAddr
+		packets   chan Packet
+		mutex     sync.Mutex
+		deadline  time.Time
+		wake      chan struct{}
+		done      chan struct{}
+		closeOnce sync.Once
+		// 解析数据报使用的协议版本, 握手以后切换
+		version uint8
+		// 在transport.idle中的位置, 由transport.mutex保护
+		element *list.Element
+	}
+)
 
-	header := data[20 : 20+headerLength]
-	body := data[20+headerLength : length]
+func newUDPTransport(address string, options Options) *udpTransport {
+	return &udpTransport{address: address, options: options, sessions: make(map[string]*udpSession), idle: list.New()}
+}
 
-	rp, err := NewPacket(convert.BytesToUint32(bType), sequence, header, body, s.options.pluginForPacketReceiver)
+func (t *udpTransport) Listen() error {
+	udpAddr, err := net.ResolveUDPAddr(NetworkUDP, t.address)
 	if err != nil {
-		return
+		return err
+	}
+
+	t.conn, err = net.ListenUDP(NetworkUDP, udpAddr)
+	if err != nil {
+		return err
+	}
+
+	if t.options.readBufferSize > 0 {
+		if err := t.conn.SetReadBuffer(t.options.readBufferSize); err != nil {
+			return err
+		}
+	}
+
+	if t.options.writeBufferSize > 0 {
+		if err := t.conn.SetWriteBuffer(t.options.writeBufferSize); err != nil {
+			return err
+		}
 	}
 
-	var ctx Context = NewContextUdp(context.Background(), conn, remote, rp.Operator, rp.Sequence, rp.Header, rp.Body, s.options)
+	fmt.Printf("Listening and serving UDP on %s\n", t.conn.LocalAddr())
 
-	ctx.Set(nodeID, uuid.NewV4().String())
+	return nil
+}
 
+func (t *udpTransport) Serve(handler func(Conn)) error {
 	defer func() {
-		if r := recover(); r != nil {
-			var errMsg string
-
-			switch v := r.(type) {
-			case string:
-				errMsg = v
-			case error:
-				errMsg = v.Error()
-			default:
-				errMsg = StatusText(StatusInternalServerError)
+		// 等待所有会话的回复发送完成以后再关闭
+		t.wg.Wait()
+		_ = t.conn.Close()
+	}()
+
+	for {
+		var data = make([]byte, t.options.udpPayload)
+		n, remote, err := t.conn.ReadFromUDP(data)
+		if err != nil {
+			if t.isClosed() {
+				return ErrServerClosed
 			}
 
-			ctx.Set(errorTag, errMsg)
+			continue
+		}
+
+		// 还没有会话的远端按照v1解析, 第一个数据报是握手或者v1请求
+		version := uint8(ProtocolV1)
+		if session := t.lookup(remote); session != nil {
+			version = session.protocol()
+		}
 
-			if s.options.errorHandler != nil {
-				s.options.errorHandler.Handle(ctx)
+		p, err := ParsePacket(data[:n], version, t.options.frameLimits())
+		if err == ErrFrameTooLarge || err == ErrChecksum || err == ErrUnsupportedFlags {
+			code := StatusRequestEntityTooLarge
+			if err != ErrFrameTooLarge {
+				code = StatusBadRequest
 			}
 
-			ctx.Error(StatusInternalServerError, errMsg)
+			// 不创建会话, 直接回复错误
+			_ = rejectPacket(&udpSession{transport: t, remote: remote}, p, code, t.options)
+			continue
 		}
 
-		if err := ctx.UnSubscribeAll(); err != nil {
-			ctx.Error(StatusInternalServerError, err.Error())
+		if err != nil {
+			continue
 		}
-	}()
 
-	if rp.Operator == OperatorHeartbeat {
-		if s.options.pingHandler != nil {
-			s.options.pingHandler.Handle(ctx)
+		session, isNew, ok := t.session(remote)
+		if !ok {
+			return ErrServerClosed
 		}
 
-		ctx.Success(nil)
-	}
+		if isNew {
+			go handler(session)
+		}
 
-	handler, ok := s.router.handlerContainer[rp.Operator]
-	if !ok {
-		ctx.Error(StatusInternalServerError, "server don't register your request.")
+		select {
+		case session.packets <- p:
+		case <-session.done:
+		}
 	}
+}
 
-	if rm, ok := s.router.routerMiddleware[rp.Operator]; ok {
-		for _, v := range rm {
-			ctx = v.Handle(ctx)
+// lookup 获取远端地址已经存在的会话
+func (t *udpTransport) lookup(remote *net.UDPAddr) *udpSession {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.sessions[remote.String()]
+}
+
+// session 获取远端地址对应的会话, 不存在时创建, 会话数量达到上限时关闭最久没有收到数据的会话
+func (t *udpTransport) session(remote *net.UDPAddr) (s *udpSession, isNew, ok bool) {
+	var evicted *udpSession
+	defer func() {
+		if evicted != nil {
+			_ = evicted.Close()
 		}
+	}()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		return nil, false, false
 	}
 
-	for _, v := range s.router.middleware {
-		ctx = v.Handle(ctx)
-		if tm, ok := v.(TerminateMiddleware); ok {
-			tm.Terminate(ctx)
-		}
+	if s, ok := t.sessions[remote.String()]; ok {
+		t.idle.MoveToBack(s.element)
+		return s, false, true
 	}
 
-	handler.Handle(ctx)
-	ctx.Success(nil) // If it don't call the function of Success or Error, deal it by default
-}
+	max := t.options.maxUDPSessions
+	if max <= 0 {
+		max = defaultMaxUDPSessions
+	}
 
-// 开始运行Tcp服务
-func (s *Server) runUDP(address string) error {
-	udpAddr, err := net.ResolveUDPAddr(NetworkUDP, address)
-	if err != nil {
-		return err
+	if len(t.sessions) >= max {
+		evicted = t.idle.Front().Value.(*udpSession)
+		t.remove(evicted)
 	}
 
-	conn, err := net.ListenUDP(NetworkUDP, udpAddr)
-	if err != nil {
-		return err
+	s = &udpSession{
+		transport: t,
+		remote:    remote,
+		packets:   make(chan Packet, 64),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
 	}
 
-	defer conn.Close()
+	t.sessions[remote.String()] = s
+	s.element = t.idle.PushBack(s)
+	t.wg.Add(1)
 
-	fmt.Printf("Listening and serving UDP on %s\n", address)
+	return s, true, true
+}
 
-	if s.options.readBufferSize > 0 {
-		err := conn.SetReadBuffer(s.options.readBufferSize)
-		if err != nil {
-			return err
-		}
+// remove 把会话从索引中移除, 调用时持有mutex
+func (t *udpTransport) remove(s *udpSession) {
+	if t.sessions[s.remote.String()] != s {
+		return
 	}
 
-	if s.options.writeBufferSize > 0 {
-		err := conn.SetWriteBuffer(s.options.writeBufferSize)
-		if err != nil {
-			return err
-		}
-	}
+	delete(t.sessions, s.remote.String())
+	t.idle.Remove(s.element)
+}
 
-	if s.options.constructHandler != nil {
-		s.options.constructHandler.Handle(nil)
-	}
+func (t *udpTransport) Close() error {
+	t.mutex.Lock()
+	t.closed = true
+	t.mutex.Unlock()
+
+	// 只中断读取, 等会话处理完以后由Serve关闭
+	return t.conn.SetReadDeadline(time.Now())
+}
+
+func (t *udpTransport) Addr() net.Addr {
+	return t.conn.LocalAddr()
+}
+
+func (t *udpTransport) isClosed() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 
+	return t.closed
+}
+
+func (s *udpSession) ReadPacket() (Packet, error) {
 	for {
-		var data = make([]byte, s.options.udpPayload)
-		n, remote, err := conn.ReadFromUDP(data)
-		if err != nil {
-			continue
+		s.mutex.Lock()
+		deadline := s.deadline
+		s.mutex.Unlock()
+
+		// 读超时或者空闲超过默认时间都结束会话
+		if idle := time.Now().Add(defaultUDPSessionTimeout); deadline.IsZero() || deadline.After(idle) {
+			deadline = idle
 		}
 
-		go s.handleUDPData(conn, remote, data, n)
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case p := <-s.packets:
+			timer.Stop()
+			return p, nil
+		case <-s.done:
+			timer.Stop()
+			return Packet{}, io.EOF
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+			return Packet{}, io.EOF
+		}
 	}
 }
+
+func (s *udpSession) setProtocol(version uint8) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.version = version
+}
+
+func (s *udpSession) protocol() uint8 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.version
+}
+
+func (s *udpSession) WritePacket(p Packet) error {
+	_, err := s.transport.conn.WriteToUDP(p.Bytes(), s.remote)
+
+	return err
+}
+
+func (s *udpSession) SetReadDeadline(t time.Time) error {
+	s.mutex.Lock()
+	s.deadline = t
+	s.mutex.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (s *udpSession) LocalAddr() net.Addr {
+	return s.transport.conn.LocalAddr()
+}
+
+func (s *udpSession) RemoteAddr() net.Addr {
+	return s.remote
+}
+
+func (s *udpSession) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		s.transport.mutex.Lock()
+		s.transport.remove(s)
+		s.transport.mutex.Unlock()
+
+		s.transport.wg.Done()
+	})
+
+	return nil
+}
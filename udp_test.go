@@ -0,0 +1,132 @@
+package linker_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker/client/export"
+	"github.com/wpajqz/linker/codec"
+)
+
+// udpAddr 等待udp传输层开始监听, 返回它的地址
+func udpAddr(t *testing.T, s *linker.Server) string {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		for _, addr := range s.Addrs() {
+			if addr.Network() == linker.NetworkUDP {
+				return addr.String()
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("udp endpoint is not listening")
+	return ""
+}
+
+func TestServerUDPEndpoint(t *testing.T) {
+	closed := make(chan string, 4)
+
+	router := linker.NewRouter()
+	router.Route("/echo", linker.HandlerFunc(func(ctx linker.Context) {
+		var v string
+		if err := ctx.ParseParam(&v); err != nil {
+			ctx.Error(linker.StatusBadRequest, err.Error())
+			return
+		}
+
+		ctx.Success(v)
+	}))
+
+	s, _, _ := runServer(t, router,
+		linker.WithUDPEndpoint(linker.Endpoint{Address: "127.0.0.1:0"}),
+		linker.MaxUDPSessions(2),
+		linker.WithOnClose(linker.HandlerFunc(func(ctx linker.Context) {
+			closed <- ctx.RemoteAddr()
+		})),
+	)
+	defer s.Shutdown(context.Background())
+
+	address := udpAddr(t, s)
+
+	c, err := export.NewUDPClient(address, &client.ReadyStateCallback{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	c.SetContentType(codec.JSON)
+
+	if c.Protocol() != linker.ProtocolV2 {
+		t.Fatalf("expected protocol v2, got %d", c.Protocol())
+	}
+
+	var v string
+	if err := c.Call(context.Background(), "/echo", "hello", &v); err != nil || v != "hello" {
+		t.Fatalf("unexpected reply: %s, %v", v, err)
+	}
+
+	// 会话达到上限时关闭最久没有收到数据的会话
+	raddr, err := net.ResolveUDPAddr(linker.NetworkUDP, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	heartbeat := func() *net.UDPConn {
+		conn, err := net.DialUDP(linker.NetworkUDP, nil, raddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, _ := linker.NewPacket(linker.OperatorHeartbeat, 1, nil, nil, nil)
+		if _, err := conn.Write(p.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 4096)); err != nil {
+			t.Fatal(err)
+		}
+
+		return conn
+	}
+
+	evicted := func() string {
+		select {
+		case addr := <-closed:
+			return addr
+		case <-time.After(time.Second):
+			t.Fatal("oldest session was not closed")
+			return ""
+		}
+	}
+
+	first := heartbeat()
+	defer first.Close()
+
+	select {
+	case addr := <-closed:
+		t.Fatalf("session closed below the limit: %s", addr)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	second := heartbeat()
+	defer second.Close()
+
+	if addr := evicted(); addr == first.LocalAddr().String() || addr == second.LocalAddr().String() {
+		t.Errorf("evicted a newer session: %s", addr)
+	}
+
+	third := heartbeat()
+	defer third.Close()
+
+	if addr := evicted(); addr != first.LocalAddr().String() {
+		t.Errorf("unexpected evicted session: %s, want %s", addr, first.LocalAddr())
+	}
+}
@@ -0,0 +1,152 @@
+package linker
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Credentials unix socket对端进程的身份
+type Credentials struct {
+	PID int
+	UID int
+	GID int
+}
+
+// credentialer 可以获取对端进程身份的连接实现该接口
+type credentialer interface {
+	PeerCredentials() *Credentials
+}
+
+// unixTransport unix domain socket传输层, 用于同一台机器上的进程通信
+type unixTransport struct {
+	path       string
+	permission os.FileMode
+	options    Options
+	mutex      sync.Mutex
+	closed     bool
+	listener   *net.UnixListener
+}
+
+func newUnixTransport(path string, permission os.FileMode, options Options) *unixTransport {
+	return &unixTransport{path: path, permission: permission, options: options}
+}
+
+func (t *unixTransport) Listen() error {
+	if err := removeStaleSocket(t.path); err != nil {
+		return err
+	}
+
+	// 设置了权限时先在只有当前用户可以访问的临时目录中创建socket,
+	// 修改权限以后再移动到path, 其它用户无法在修改权限之前连接
+	path := t.path
+	if t.permission != 0 {
+		dir, err := ioutil.TempDir(filepath.Dir(t.path), ".linker")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		path = filepath.Join(dir, "s")
+	}
+
+	addr, err := net.ResolveUnixAddr(NetworkUnix, path)
+	if err != nil {
+		return err
+	}
+
+	t.listener, err = net.ListenUnix(NetworkUnix, addr)
+	if err != nil {
+		return err
+	}
+
+	// 监听的地址和移动以后的path不同, 关闭时由Close删除socket文件
+	t.listener.SetUnlinkOnClose(false)
+
+	if t.permission != 0 {
+		if err := os.Chmod(path, t.permission); err != nil {
+			_ = t.listener.Close()
+			return err
+		}
+
+		if err := os.Rename(path, t.path); err != nil {
+			_ = t.listener.Close()
+			return err
+		}
+	}
+
+	fmt.Printf("Listening and serving UNIX on %s\n", t.path)
+
+	return nil
+}
+
+func (t *unixTransport) Serve(handler func(Conn)) error {
+	for {
+		conn, err := t.listener.AcceptUnix()
+		if err != nil {
+			if t.isClosed() {
+				return ErrServerClosed
+			}
+
+			continue
+		}
+
+		sc := newStreamConn(conn, t.options)
+		if creds, err := peerCredentials(conn); err == nil {
+			sc.credentials = creds
+		}
+
+		go handler(sc)
+	}
+}
+
+func (t *unixTransport) Close() error {
+	t.mutex.Lock()
+	t.closed = true
+	t.mutex.Unlock()
+
+	err := t.listener.Close()
+	if rerr := os.Remove(t.path); err == nil && rerr != nil && !os.IsNotExist(rerr) {
+		err = rerr
+	}
+
+	return err
+}
+
+func (t *unixTransport) Addr() net.Addr {
+	return &net.UnixAddr{Name: t.path, Net: NetworkUnix}
+}
+
+func (t *unixTransport) isClosed() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.closed
+}
+
+// removeStaleSocket 进程异常退出时socket文件不会被删除, 没有进程监听时删除旧的文件
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return errors.New("linker: " + path + " exists and is not a unix socket")
+	}
+
+	if conn, err := net.Dial(NetworkUnix, path); err == nil {
+		_ = conn.Close()
+		return errors.New("linker: " + path + " is already in use")
+	}
+
+	return os.Remove(path)
+}
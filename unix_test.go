@@ -0,0 +1,102 @@
+package linker_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/wpajqz/linker"
+	"github.com/wpajqz/linker/client"
+	"github.com/wpajqz/linker/client/export"
+	"github.com/wpajqz/linker/codec"
+)
+
+func TestServerUnixEndpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "linker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "linker.sock")
+
+	// 模拟进程异常退出以后残留的socket文件
+	stale, err := net.ListenUnix(linker.NetworkUnix, &net.UnixAddr{Name: path, Net: linker.NetworkUnix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	router := linker.NewRouter()
+	router.Route("/whoami", linker.HandlerFunc(func(ctx linker.Context) {
+		creds := ctx.PeerCredentials()
+		if creds == nil {
+			ctx.Success("")
+			return
+		}
+
+		ctx.Success(fmt.Sprint(creds.PID))
+	}))
+
+	s, _, _ := runServer(t, router, linker.WithUnixEndpoint(path), linker.UnixSocketPermission(0600))
+	defer s.Shutdown(context.Background())
+
+	// runServer只等待第一个传输层开始监听
+	for i := 0; i < 100 && len(s.Addrs()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("unexpected permission: %v", fi.Mode().Perm())
+	}
+
+	c, err := export.NewUnixClient(path, &client.ReadyStateCallback{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetContentType(codec.JSON)
+
+	reply := make(chan string, 1)
+	err = c.SyncSend("/whoami", nil, client.RequestStatusCallback{
+		Success: func(header, body []byte) { reply <- string(body) },
+		Error:   func(code int, message string) { reply <- message },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `""`
+	if runtime.GOOS == "linux" {
+		want = fmt.Sprintf(`"%d"`, os.Getpid())
+	}
+
+	select {
+	case v := <-reply:
+		if v != want {
+			t.Errorf("expected %s, got %s", want, v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply")
+	}
+
+	// 创建socket使用的临时目录已经删除, 关闭以后删除socket文件
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, err := ioutil.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("unexpected files after shutdown: %v, %v", entries, err)
+	}
+}